@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hebo/mailshine/models"
+)
+
+// cassetteDBPath is where the database is saved when recording a cassette,
+// so replays start from the same digests and delivered stories
+func cassetteDBPath(cassettePath string) string {
+	return cassettePath + ".db"
+}
+
+// snapshotDB copies the database next to a cassette being recorded. There's
+// nothing to copy if the database doesn't exist yet
+func snapshotDB(dbPath, cassettePath string) error {
+	_, err := os.Stat(dbPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return copyFile(dbPath, cassetteDBPath(cassettePath))
+}
+
+// replayDB copies a cassette's database snapshot to a scratch directory, so
+// replaying never writes to the real database. The caller should remove dir
+func replayDB(cassettePath string) (path, dir string, err error) {
+	dir, err = ioutil.TempDir("", "mailshine-replay")
+	if err != nil {
+		return "", "", err
+	}
+	path = filepath.Join(dir, "shine.db")
+
+	snapshot := cassetteDBPath(cassettePath)
+	if _, err := os.Stat(snapshot); os.IsNotExist(err) {
+		return path, dir, nil
+	}
+	return path, dir, copyFile(snapshot, path)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// printDigests writes the latest digest of each feed, with its stories
+func printDigests(w io.Writer, db models.DB, feeds models.FeedConfigMap) error {
+	var names []string
+	for name := range feeds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		count, err := db.CountDigestsByFeed(name)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			fmt.Fprintf(w, "%s: no digest\n\n", name)
+			continue
+		}
+
+		dg, err := db.GetLatestDigestByFeed(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s (%s)\n", dg.Title, dg.CreatedAt.Format("2006-01-02 15:04"))
+		for _, block := range dg.Content {
+			fmt.Fprintf(w, "  %s\n", block.Title)
+			for _, story := range block.Stories {
+				fmt.Fprintf(w, "    %s\n      %s\n", story.Title, story.Link)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
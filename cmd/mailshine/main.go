@@ -4,6 +4,7 @@ import (
 	"flag"
 	"log"
 	"os"
+	"time"
 
 	"github.com/hebo/mailshine/models"
 	"github.com/hebo/mailshine/providers"
@@ -22,11 +23,40 @@ func main() {
 	flagGenFeeds := flag.Bool("generate", false, "Generate feeds")
 	flagInit := flag.Bool("init", false, "Initialize database")
 	flagPort := flag.Int("port", 8080, "Listen port")
+	flagRecord := flag.String("record", "", "Generate feeds, recording provider HTTP requests to a cassette file")
+	flagReplay := flag.String("replay", "", "Generate feeds from a cassette file into a scratch database, and print them")
 	flag.Parse()
 
 	conf := loadConfig()
 	log.Printf("Config loaded - %d feed configs found\n", len(conf.FeedConfigs))
 
+	var cassette *providers.Cassette
+	var err error
+	switch {
+	case *flagRecord != "" && *flagReplay != "":
+		log.Fatalln("Only one of -record and -replay can be set")
+	case *flagRecord != "":
+		log.Printf("Recording provider requests to %s\n", *flagRecord)
+		err = snapshotDB(dbPath, *flagRecord)
+		if err != nil {
+			log.Fatalf("Failed to save database with cassette: %s", err)
+		}
+		cassette = providers.RecordCassette(*flagRecord)
+	case *flagReplay != "":
+		log.Printf("Replaying provider requests from %s\n", *flagReplay)
+		cassette, err = providers.LoadCassette(*flagReplay)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		var dir string
+		dbPath, dir, err = replayDB(*flagReplay)
+		if err != nil {
+			log.Fatalf("Failed to create scratch database: %s", err)
+		}
+		defer os.RemoveAll(dir)
+	}
+	withCassette := providers.WithCassette(cassette)
+
 	db, err := models.NewDB(dbPath)
 	if err != nil {
 		log.Fatalln("could not get db", err)
@@ -42,12 +72,99 @@ func main() {
 
 	reddit, err := providers.NewRedditClient(
 		os.Getenv("REDDIT_CLIENT_ID"),
-		os.Getenv("REDDIT_CLIENT_SECRET"))
+		os.Getenv("REDDIT_CLIENT_SECRET"),
+		withCassette,
+		providers.WithBaseURL(os.Getenv("REDDIT_API_URL")),
+		providers.WithTokenURL(os.Getenv("REDDIT_TOKEN_URL")),
+		providers.WithWebURL(os.Getenv("REDDIT_WEB_URL")))
 	if err != nil {
 		log.Fatalf("Failed to create reddit client: %s", err)
 	}
 
-	svc := service.NewService(db, conf.FeedConfigs, reddit)
+	registry := providers.NewRegistry()
+	registry.Register(models.SourceTypeReddit, reddit)
+	registry.Register(models.SourceTypeHackerNews, providers.NewHackerNewsClient(
+		withCassette,
+		providers.WithBaseURL(os.Getenv("HACKERNEWS_API_URL"))))
+	registry.Register(models.SourceTypeFeed, providers.NewFeedClient(withCassette))
+	registry.Register(models.SourceTypeMastodon, providers.NewMastodonClient(
+		withCassette,
+		providers.WithBaseURL(os.Getenv("MASTODON_API_URL"))))
+	registry.Register(models.SourceTypeLobsters, providers.NewLobstersClient(
+		withCassette,
+		providers.WithBaseURL(os.Getenv("LOBSTERS_URL"))))
+	registry.Register(models.SourceTypeLemmy, providers.NewLemmyClient(
+		withCassette,
+		providers.WithBaseURL(os.Getenv("LEMMY_API_URL"))))
+	registry.Register(models.SourceTypeGitHub, providers.NewGitHubClient(
+		os.Getenv("GITHUB_TOKEN"),
+		withCassette,
+		providers.WithBaseURL(os.Getenv("GITHUB_API_URL"))))
+	registry.Register(models.SourceTypeStackExchange, providers.NewStackExchangeClient(
+		os.Getenv("STACKEXCHANGE_KEY"),
+		withCassette,
+		providers.WithBaseURL(os.Getenv("STACKEXCHANGE_API_URL"))))
+	registry.Register(models.SourceTypeYouTube, providers.NewYouTubeClient(
+		withCassette,
+		providers.WithBaseURL(os.Getenv("YOUTUBE_FEED_URL"))))
+	registry.Register(models.SourceTypePodcast, providers.NewPodcastClient(withCassette))
+
+	imap, err := providers.NewIMAPClient(
+		os.Getenv("IMAP_ADDR"),
+		os.Getenv("IMAP_USERNAME"),
+		os.Getenv("IMAP_PASSWORD"),
+		os.Getenv("IMAP_TLS") != "false")
+	if err != nil {
+		log.Printf("IMAP sources disabled: %s", err)
+	} else if *flagReplay != "" {
+		// IMAP isn't HTTP, so it's not in the cassette, and would read the live mailbox
+		log.Println("IMAP sources disabled while replaying")
+	} else {
+		registry.Register(models.SourceTypeIMAP, imap)
+	}
+
+	twitter, err := providers.NewTwitterClient(
+		os.Getenv("TWITTER_BEARER_TOKEN"),
+		withCassette,
+		providers.WithBaseURL(os.Getenv("TWITTER_API_URL")))
+	if err != nil {
+		log.Printf("Twitter sources disabled: %s", err)
+	} else {
+		registry.Register(models.SourceTypeTwitter, twitter)
+	}
+
+	svcOpts := []service.Option{service.WithConcurrency(conf.Fetch.Concurrency)}
+	for sourceType, n := range conf.Fetch.ProviderConcurrency {
+		svcOpts = append(svcOpts, service.WithProviderConcurrency(sourceType, n))
+	}
+	if conf.Fetch.DigestTimeout != "" {
+		timeout, err := time.ParseDuration(conf.Fetch.DigestTimeout)
+		if err != nil {
+			log.Fatalf("Invalid digest_timeout: %s\n", err)
+		}
+		svcOpts = append(svcOpts, service.WithDigestTimeout(timeout))
+	}
+
+	if *flagReplay != "" {
+		// Create digests at the time of the recording, so sources filter by time as they did then
+		svcOpts = append(svcOpts, service.WithClock(cassette.RecordedAt))
+	}
+
+	svc := service.NewService(db, conf.FeedConfigs, registry, svcOpts...)
+	if cassette != nil {
+		err = svc.CreateAllDigests()
+		if err != nil {
+			log.Println(err)
+		}
+		if *flagReplay != "" {
+			err = printDigests(os.Stdout, db, conf.FeedConfigs)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		return
+	}
+
 	err = svc.StartScheduler()
 	if err != nil {
 		log.Fatalln(err)
@@ -64,9 +181,20 @@ func main() {
 
 type config struct {
 	BaseURL     string               `toml:"base_url"`
+	Fetch       fetchConfig          `toml:"fetch"`
 	FeedConfigs models.FeedConfigMap `toml:"feeds"`
 }
 
+// fetchConfig limits how sources are fetched. Unset values use the defaults
+type fetchConfig struct {
+	// Concurrency is how many sources are fetched at once, across all feeds
+	Concurrency int `toml:"concurrency"`
+	// ProviderConcurrency is how many sources of a type are fetched at once
+	ProviderConcurrency map[string]int `toml:"provider_concurrency"`
+	// DigestTimeout is how long fetching a digest's sources can take, e.g. "2m"
+	DigestTimeout string `toml:"digest_timeout"`
+}
+
 func loadConfig() config {
 	fc := config{}
 	fi, err := os.Open(configFilename)
@@ -18,6 +18,17 @@ CREATE TABLE digests (
 );
 `
 
+// migrations run on every connection, so must be safe to repeat. They add
+// tables to databases created before the tables existed
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS delivered_stories (
+    digest_id INTEGER NOT NULL,
+    feed_name text NOT NULL,
+    story_key text NOT NULL
+);`,
+	`CREATE INDEX IF NOT EXISTS delivered_stories_feed ON delivered_stories (feed_name, digest_id);`,
+}
+
 // NewDB creates a new DB
 func NewDB(filename string) (DB, error) {
 	database := DB{}
@@ -25,7 +36,10 @@ func NewDB(filename string) (DB, error) {
 	log.Printf("Connecting to database %q", filename)
 	if _, err := os.Stat(filename); err == nil {
 		database.db, err = sqlx.Connect("sqlite3", filename)
-		return database, err
+		if err != nil {
+			return database, err
+		}
+		return database, database.Migrate()
 	}
 
 	log.Println("Database does not exist, initializing schema")
@@ -34,7 +48,11 @@ func NewDB(filename string) (DB, error) {
 		return database, err
 	}
 
-	return database, database.InitializeSchema()
+	err = database.InitializeSchema()
+	if err != nil {
+		return database, err
+	}
+	return database, database.Migrate()
 }
 
 // DB holds the database. Don't drop it
@@ -53,11 +71,75 @@ func (d *DB) InitializeSchema() error {
 	return err
 }
 
-func (d *DB) InsertDigest(digest Digest) error {
-	_, err := d.db.NamedExec(`INSERT INTO digests (feed_name, title, content, created_at) VALUES (:feed_name, :title, :content, :created_at)`,
+// Migrate brings the schema of an existing database up to date
+func (d *DB) Migrate() error {
+	for _, m := range migrations {
+		_, err := d.db.Exec(m)
+		if err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// InsertDigest inserts a digest, and records its stories as delivered. Only
+// the stories of the feed's latest keepDelivered digests are kept, including
+// this one, so nothing is recorded if it's 0
+func (d *DB) InsertDigest(digest Digest, keepDelivered int) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.NamedExec(`INSERT INTO digests (feed_name, title, content, created_at) VALUES (:feed_name, :title, :content, :created_at)`,
 		digest)
+	if err != nil {
+		return err
+	}
+	digestID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
 
-	return err
+	for _, block := range digest.Content {
+		if keepDelivered == 0 {
+			break
+		}
+		for _, story := range block.Stories {
+			for _, key := range story.Keys() {
+				_, err = tx.Exec(`INSERT INTO delivered_stories (digest_id, feed_name, story_key) VALUES ($1, $2, $3)`,
+					digestID, digest.FeedName, key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM delivered_stories WHERE feed_name=$1 AND digest_id NOT IN
+		(SELECT id FROM digests WHERE feed_name=$1 ORDER BY datetime(created_at) DESC LIMIT $2)`, digest.FeedName, keepDelivered)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDeliveredStoryKeys returns the keys of stories in a feed's latest n digests
+func (d *DB) GetDeliveredStoryKeys(feedName string, n int) (map[string]bool, error) {
+	var keys []string
+	err := d.db.Select(&keys, `SELECT story_key FROM delivered_stories WHERE feed_name=$1 AND digest_id IN
+		(SELECT id FROM digests WHERE feed_name=$1 ORDER BY datetime(created_at) DESC LIMIT $2)`, feedName, n)
+	if err != nil {
+		return nil, err
+	}
+
+	delivered := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		delivered[k] = true
+	}
+	return delivered, nil
 }
 
 func (d *DB) GetDigests() ([]Digest, error) {
@@ -72,6 +154,13 @@ func (d *DB) GetDigestsByFeed(name string) ([]Digest, error) {
 	return digests, err
 }
 
+// GetLatestDigestByFeed returns the most recently created digest for a feed
+func (d *DB) GetLatestDigestByFeed(name string) (Digest, error) {
+	digest := Digest{}
+	err := d.db.Get(&digest, "SELECT * FROM digests WHERE feed_name=$1 ORDER BY datetime(created_at) DESC LIMIT 1", name)
+	return digest, err
+}
+
 func (d *DB) GetDigestByID(id string) (Digest, error) {
 	digest := Digest{}
 	err := d.db.Get(&digest, "SELECT * FROM digests WHERE id=$1", id)
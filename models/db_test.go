@@ -0,0 +1,59 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_GetDeliveredStoryKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailshine")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := NewDB(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+
+	start := time.Now().Add(-time.Hour)
+	for i, link := range []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"} {
+		err = db.InsertDigest(Digest{
+			FeedName:  "news",
+			Title:     "News",
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+			Content:   ContentBlocks{{Stories: []Story{{ID: "t3_" + link[len(link)-1:], Link: link}}}},
+		}, 2)
+		require.NoError(t, err)
+	}
+
+	// Reconnecting runs the migrations again
+	db, err = NewDB(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+
+	keys, err := db.GetDeliveredStoryKeys("news", 2)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{
+		"id:t3_2": true, "link:example.com/2?": true,
+		"id:t3_3": true, "link:example.com/3?": true,
+	}, keys)
+
+	keys, err = db.GetDeliveredStoryKeys("other", 2)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	// Stories of older digests are deleted as new ones are inserted
+	var count int
+	err = db.db.Get(&count, `SELECT COUNT(*) FROM delivered_stories WHERE feed_name='news'`)
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+
+	err = db.InsertDigest(Digest{FeedName: "news", Title: "News", CreatedAt: time.Now(),
+		Content: ContentBlocks{{Stories: []Story{{Link: "https://example.com/4"}}}}}, 0)
+	require.NoError(t, err)
+	err = db.db.Get(&count, `SELECT COUNT(*) FROM delivered_stories WHERE feed_name='news'`)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
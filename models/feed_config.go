@@ -1,6 +1,12 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
 
 type FeedConfigMap map[string]FeedConfig
 
@@ -13,7 +19,105 @@ func contains(s []string, e string) bool {
 	return false
 }
 
-var validTimePeriods = []string{"day", "week"}
+// TimePeriodAuto derives a feed's time period from the interval between its
+// scheduled runs, so consecutive digests neither overlap nor leave gaps
+const TimePeriodAuto = "auto"
+
+var validTimePeriods = []string{"hour", "day", "week", "month", "year", "all"}
+
+// periodDurations are ordered from shortest to longest. "all" has no duration
+var periodDurations = []struct {
+	period   string
+	duration time.Duration
+}{
+	{"hour", time.Hour},
+	{"day", 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"month", 31 * 24 * time.Hour},
+	{"year", 366 * 24 * time.Hour},
+}
+
+var (
+	validRedditSorts = []string{"hot", "new", "rising", "controversial", "top", "best"}
+	// redditPeriodSorts are the sorts Reddit applies a time period to
+	redditPeriodSorts = []string{"top", "controversial"}
+	// validRedditSearchSorts are the sorts for reddit sources with a Query
+	validRedditSearchSorts = []string{"relevance", "hot", "top", "new", "comments"}
+	// validRedditUserSorts are the sorts for a user's submitted posts
+	validRedditUserSorts = []string{"hot", "new", "top", "controversial"}
+)
+
+const (
+	// LayoutSources shows a block for each source
+	LayoutSources = "sources"
+	// LayoutMerged ranks the stories of all sources in a single block
+	LayoutMerged = "merged"
+)
+
+// RedditFrontPage is the reddit source name for the front page, rather than a subreddit
+const RedditFrontPage = "frontpage"
+
+// IsRedditUser reports whether a reddit source name is a user's submitted
+// posts, as u/name or user/name, rather than a subreddit or multireddit
+func IsRedditUser(name string) bool {
+	parts := strings.Split(name, "/")
+	return len(parts) == 2 && (parts[0] == "u" || parts[0] == "user")
+}
+
+// RedditSortUsesPeriod reports whether Reddit applies a time period to a sort
+func RedditSortUsesPeriod(sort string) bool {
+	return contains(redditPeriodSorts, sort)
+}
+
+// PeriodDuration returns the length of a time period, e.g. "day". It's zero
+// for "all", which has no limit
+func PeriodDuration(period string) time.Duration {
+	for _, p := range periodDurations {
+		if p.period == period {
+			return p.duration
+		}
+	}
+	return 0
+}
+
+// PeriodCovering returns the shortest time period which is at least as long as d
+func PeriodCovering(d time.Duration) string {
+	for _, p := range periodDurations {
+		if p.duration >= d {
+			return p.period
+		}
+	}
+	return "all"
+}
+
+// scheduleSlack is how long after a scheduled run at can be, and still be that
+// run rather than the one after it. Cron runs never start on the exact instant
+const scheduleSlack = time.Minute
+
+// ScheduleInterval returns how long before at the schedule's previous run was,
+// or would have been. A run within scheduleSlack before at is the current run
+func ScheduleInterval(schedule string, loc *time.Location, at time.Time) (time.Duration, error) {
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return 0, err
+	}
+
+	// There's no way to ask for the previous run, so look back further and
+	// further, then step forwards to the last run before at
+	at = at.In(loc)
+	current := at.Add(-scheduleSlack)
+	for _, p := range periodDurations {
+		prev := time.Time{}
+		for t := sched.Next(at.Add(-p.duration)); t.Before(current); t = sched.Next(t) {
+			prev = t
+		}
+		if !prev.IsZero() {
+			return at.Sub(prev), nil
+		}
+	}
+
+	return 0, fmt.Errorf("schedule %q runs less than once a year", schedule)
+}
 
 // Validate performs basic checks to ensure FeedConfig is initialized properly
 func (c FeedConfig) Validate() error {
@@ -25,19 +129,236 @@ func (c FeedConfig) Validate() error {
 		return fmt.Errorf("feed Config %q: Schedule is not set", c.Title)
 	}
 
-	if !contains(validTimePeriods, c.TimePeriod) {
+	if !isValidTimePeriod(c.TimePeriod) {
 		return fmt.Errorf("feed Config %q: invalid TimePeriod", c.Title)
 	}
 
+	if c.Layout != "" && c.Layout != LayoutSources && c.Layout != LayoutMerged {
+		return fmt.Errorf("feed Config %q: invalid Layout %q", c.Title, c.Layout)
+	}
+
+	_, err := cron.ParseStandard(c.Schedule)
+	if err != nil {
+		return fmt.Errorf("feed Config %q: invalid Schedule: %w", c.Title, err)
+	}
+
+	err = c.Filter.Validate()
+	if err != nil {
+		return fmt.Errorf("feed Config %q: %w", c.Title, err)
+	}
+
+	for _, src := range c.AllSources() {
+		if src.Type == "" || (src.Name == "" && len(src.Names) == 0) {
+			return fmt.Errorf("feed Config %q: source is missing a type or name", c.Title)
+		}
+		if !isValidTimePeriod(src.TimePeriod) {
+			return fmt.Errorf("feed Config %q: invalid TimePeriod for source %q", c.Title, src.Name)
+		}
+		// Only reddit stories have everything a filter matches on
+		if src.Type != SourceTypeReddit && !src.Filter.IsEmpty() {
+			return fmt.Errorf("feed Config %q: filter is only supported for reddit sources, not %q", c.Title, src.Name)
+		}
+		err := src.Filter.Validate()
+		if err != nil {
+			return fmt.Errorf("feed Config %q: source %q: %w", c.Title, src.Name, err)
+		}
+		if src.Type == SourceTypeReddit {
+			err := src.validateReddit()
+			if err != nil {
+				return fmt.Errorf("feed Config %q: %w", c.Title, err)
+			}
+		}
+	}
+
 	return nil
 }
 
+func isValidTimePeriod(period string) bool {
+	return period == TimePeriodAuto || contains(validTimePeriods, period)
+}
+
+// validateReddit checks options which only apply to reddit sources
+func (s Source) validateReddit() error {
+	if s.Query != "" && IsRedditUser(s.Name) {
+		return fmt.Errorf("query is not supported for user source %q", s.Name)
+	}
+	if s.Sort == "" {
+		return nil
+	}
+	if s.Query != "" {
+		if !contains(validRedditSearchSorts, s.Sort) {
+			return fmt.Errorf("invalid search Sort %q for source %q", s.Sort, s.Name)
+		}
+		return nil
+	}
+	if IsRedditUser(s.Name) && !contains(validRedditUserSorts, s.Sort) {
+		return fmt.Errorf("invalid Sort %q for user source %q", s.Sort, s.Name)
+	}
+	if !contains(validRedditSorts, s.Sort) {
+		return fmt.Errorf("invalid Sort %q for source %q", s.Sort, s.Name)
+	}
+	// Reddit only sorts by best on the front page
+	if s.Sort == "best" && s.Name != RedditFrontPage {
+		return fmt.Errorf("sort %q is only supported for %q, not source %q", s.Sort, RedditFrontPage, s.Name)
+	}
+
+	return nil
+}
+
+// AllSources returns every source in the feed, with feed-level settings applied
+// to any source that doesn't override them. Reddits are listed first.
+func (c FeedConfig) AllSources() []Source {
+	sources := make([]Source, 0, len(c.Reddits)+len(c.Sources))
+	for _, name := range c.Reddits {
+		sources = append(sources, Source{Type: SourceTypeReddit, Name: name})
+	}
+	sources = append(sources, c.Sources...)
+
+	for i := range sources {
+		if sources[i].NumItems == 0 {
+			sources[i].NumItems = c.NumItems
+		}
+		if sources[i].TimePeriod == "" {
+			sources[i].TimePeriod = c.TimePeriod
+		}
+		if sources[i].Sort == "" {
+			sources[i].Sort = c.Sort
+		}
+		if sources[i].Comments == 0 {
+			sources[i].Comments = c.Comments
+		}
+		if sources[i].Type == SourceTypeReddit {
+			sources[i].Filter = sources[i].Filter.Merge(c.Filter)
+		}
+	}
+	return sources
+}
+
 // FeedConfig is the configuration for a single Feed
 type FeedConfig struct {
 	Title      string
 	Reddits    []string
-	NumItems   int    `toml:"num_items"`
-	TimePeriod string `toml:"time_period"`
+	Sources    []Source `toml:"sources"`
+	NumItems   int      `toml:"num_items"`
+	TimePeriod string   `toml:"time_period"`
+	// Sort is how reddit sources are sorted, e.g. "hot" or "new". Defaults to "top"
+	Sort string `toml:"sort"`
+	// Comments is how many top comments to include with each reddit story
+	Comments int `toml:"comments"`
+	// Filter is applied to the stories of every reddit source
+	Filter Filter `toml:"filter"`
+	// Layout is how the digest is laid out: LayoutSources (the default) or LayoutMerged
+	Layout string `toml:"layout"`
+	// DedupeDigests skips stories which were in any of this many previous digests
+	DedupeDigests int `toml:"dedupe_digests"`
+	// DedupeLinks skips stories linking to the same page as an earlier story
+	// in the digest, e.g. a news article posted to several subreddits, and
+	// fetches others in their place. Otherwise they're grouped with the
+	// earlier story as its Discussions
+	DedupeLinks bool `toml:"dedupe_links"`
 	// Schedule is in crontab syntax
 	Schedule string `toml:"schedule"`
 }
+
+// Source types, each handled by a provider
+const (
+	// SourceTypeReddit sources are subreddit names, or RedditFrontPage
+	SourceTypeReddit = "reddit"
+	// SourceTypeHackerNews sources are story tags, e.g. "story" or "ask_hn"
+	SourceTypeHackerNews = "hackernews"
+	// SourceTypeFeed sources are RSS, Atom or JSON Feed URLs
+	SourceTypeFeed = "rss"
+	// SourceTypeTwitter sources are account usernames
+	SourceTypeTwitter = "twitter"
+	// SourceTypeMastodon sources are accounts as "@user@host" or hashtags as "#tag@host"
+	SourceTypeMastodon = "mastodon"
+	// SourceTypeLobsters sources are tags, or "top" for the whole site
+	SourceTypeLobsters = "lobsters"
+	// SourceTypeLemmy sources are communities as "community@instance"
+	SourceTypeLemmy = "lemmy"
+	// SourceTypeGitHub sources are repositories as "owner/repo", or "trending"
+	// optionally followed by a language, e.g. "trending:go"
+	SourceTypeGitHub = "github"
+	// SourceTypeStackExchange sources are tags on a site as "tags@site", e.g.
+	// "go@stackoverflow". Multiple tags are separated by semicolons
+	SourceTypeStackExchange = "stackexchange"
+	// SourceTypeYouTube sources are channel IDs
+	SourceTypeYouTube = "youtube"
+	// SourceTypePodcast sources are podcast feed URLs
+	SourceTypePodcast = "podcast"
+	// SourceTypeIMAP sources are mailbox folders, e.g. "INBOX"
+	SourceTypeIMAP = "imap"
+)
+
+// Source is a single place content is fetched from, such as a subreddit
+type Source struct {
+	// Type selects the provider which handles the source
+	Type string `toml:"type"`
+	// Name identifies the source to its provider, e.g. a subreddit name. Reddit
+	// also accepts multireddits as user/name/m/multi, and users as u/name
+	Name string `toml:"name"`
+	// Names lists several names for providers which accept them, e.g. accounts
+	Names []string `toml:"names"`
+	// Merge combines content from all Names into a single block
+	Merge bool `toml:"merge"`
+	// Title overrides the title of the source's blocks
+	Title      string `toml:"title"`
+	NumItems   int    `toml:"num_items"`
+	TimePeriod string `toml:"time_period"`
+	// Sort overrides the feed's Sort for reddit sources
+	Sort string `toml:"sort"`
+	// Comments overrides the feed's Comments for reddit sources
+	Comments int `toml:"comments"`
+	// Query searches a reddit source for posts, instead of listing them
+	Query string `toml:"query"`
+	// Filter adds to the feed's Filter for reddit sources, overriding rules it sets
+	Filter Filter `toml:"filter"`
+	// AcceptedAnswers includes the accepted answer of Stack Exchange questions
+	AcceptedAnswers bool `toml:"accepted_answers"`
+	// Senders limits IMAP sources to messages from these addresses
+	Senders []string `toml:"senders"`
+	// MarkRead marks IMAP messages as read once they're in a digest
+	MarkRead bool `toml:"mark_read"`
+
+	// Since is when the feed's previous digest was created, zero if there is none
+	Since time.Time `toml:"-"`
+	// Now is when the digest is created, which the time period ends at. Zero
+	// means the current time
+	Now time.Time `toml:"-"`
+	// Window, if set, narrows the time period to exactly this long. It's set
+	// for TimePeriodAuto, where TimePeriod becomes the period covering it
+	Window time.Duration `toml:"-"`
+}
+
+// SetWindow narrows the source to content posted within d, using the shortest
+// time period which covers it for providers that only accept named periods
+func (s *Source) SetWindow(d time.Duration) {
+	s.Window = d
+	s.TimePeriod = PeriodCovering(d)
+}
+
+// AllNames returns Names, or Name if there are none
+func (s Source) AllNames() []string {
+	if len(s.Names) > 0 {
+		return s.Names
+	}
+	return []string{s.Name}
+}
+
+// Cutoff returns the earliest time content should have been posted to be
+// included, based on the window or time period. It's the zero time if
+// there's no limit
+func (s Source) Cutoff() time.Time {
+	now := s.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	if s.Window > 0 {
+		return now.Add(-s.Window)
+	}
+	if d := PeriodDuration(s.TimePeriod); d > 0 {
+		return now.Add(-d)
+	}
+	return time.Time{}
+}
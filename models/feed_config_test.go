@@ -0,0 +1,126 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedConfig_Validate(t *testing.T) {
+	valid := func() FeedConfig {
+		return FeedConfig{
+			Title:      "Test",
+			Reddits:    []string{"golang"},
+			NumItems:   5,
+			TimePeriod: "day",
+			Schedule:   "0 8 * * *",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *FeedConfig)
+		wantErr bool
+	}{
+		{"valid", func(c *FeedConfig) {}, false},
+		{"missing num items", func(c *FeedConfig) { c.NumItems = 0 }, true},
+		{"bad time period", func(c *FeedConfig) { c.TimePeriod = "fortnight" }, true},
+		{"auto time period", func(c *FeedConfig) { c.TimePeriod = TimePeriodAuto }, false},
+		{"bad schedule", func(c *FeedConfig) { c.Schedule = "every day" }, true},
+		{"feed sort", func(c *FeedConfig) { c.Sort = "new" }, false},
+		{"bad sort", func(c *FeedConfig) { c.Sort = "newest" }, true},
+		{"best on subreddit", func(c *FeedConfig) { c.Sort = "best" }, true},
+		{"best on front page", func(c *FeedConfig) {
+			c.Sources = []Source{{Type: SourceTypeReddit, Name: RedditFrontPage, Sort: "best"}}
+		}, false},
+		{"source sort override", func(c *FeedConfig) {
+			c.Sources = []Source{{Type: SourceTypeReddit, Name: "asksf", Sort: "controversial"}}
+		}, false},
+		{"search sort", func(c *FeedConfig) {
+			c.Sources = []Source{{Type: SourceTypeReddit, Name: "sanfrancisco", Query: "restaurant", Sort: "relevance"}}
+		}, false},
+		{"bad search sort", func(c *FeedConfig) {
+			c.Sources = []Source{{Type: SourceTypeReddit, Name: "sanfrancisco", Query: "restaurant", Sort: "rising"}}
+		}, true},
+		{"user search", func(c *FeedConfig) {
+			c.Sources = []Source{{Type: SourceTypeReddit, Name: "u/spez", Query: "reddit"}}
+		}, true},
+		{"bad user sort", func(c *FeedConfig) {
+			c.Sources = []Source{{Type: SourceTypeReddit, Name: "u/spez", Sort: "rising"}}
+		}, true},
+		{"bad filter pattern", func(c *FeedConfig) {
+			c.Filter = Filter{Include: []string{"("}}
+		}, true},
+		{"bad source filter pattern", func(c *FeedConfig) {
+			c.Filter = Filter{Include: []string{"go"}}
+			c.Sources = []Source{{Type: SourceTypeReddit, Name: "asksf", Filter: Filter{Include: []string{"+"}}}}
+		}, true},
+		{"filter on other source", func(c *FeedConfig) {
+			c.Sources = []Source{{Type: SourceTypeHackerNews, Name: "top", Filter: Filter{MinScore: 10}}}
+		}, true},
+		{"feed filter with other source", func(c *FeedConfig) {
+			c.Filter = Filter{MinScore: 10}
+			c.Sources = []Source{{Type: SourceTypeHackerNews, Name: "top"}}
+		}, false},
+		{"bad upvote ratio", func(c *FeedConfig) {
+			c.Sources = []Source{{Type: SourceTypeReddit, Name: "asksf", Filter: Filter{MinUpvoteRatio: 90}}}
+		}, true},
+		{"merged layout", func(c *FeedConfig) { c.Layout = LayoutMerged }, false},
+		{"bad layout", func(c *FeedConfig) { c.Layout = "grid" }, true},
+		{"source missing name", func(c *FeedConfig) {
+			c.Sources = []Source{{Type: SourceTypeFeed}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(&c)
+			err := c.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestScheduleInterval(t *testing.T) {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	require.NoError(t, err)
+
+	// Thursday 8am, three days after the Monday run
+	thursday := time.Date(2020, 12, 3, 8, 0, 0, 0, loc)
+	interval, err := ScheduleInterval("0 8 * * 1,4", loc, thursday)
+	require.NoError(t, err)
+	require.Equal(t, 72*time.Hour, interval)
+	require.Equal(t, "week", PeriodCovering(interval))
+
+	// Monday, four days after the Thursday run
+	interval, err = ScheduleInterval("0 8 * * 1,4", loc, thursday.AddDate(0, 0, 4))
+	require.NoError(t, err)
+	require.Equal(t, 96*time.Hour, interval)
+
+	interval, err = ScheduleInterval("45 7 * * *", loc, time.Date(2020, 12, 3, 7, 45, 0, 0, loc))
+	require.NoError(t, err)
+	require.Equal(t, 24*time.Hour, interval)
+	require.Equal(t, "day", PeriodCovering(interval))
+
+	// Scheduled runs start a little after the scheduled time
+	interval, err = ScheduleInterval("0 8 * * 1,4", loc, thursday.Add(3*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 72*time.Hour+3*time.Second, interval)
+	interval, err = ScheduleInterval("0 8 * * 1,4", loc, thursday.Add(3*time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 72*time.Hour+3*time.Millisecond, interval)
+}
+
+func TestSource_Cutoff(t *testing.T) {
+	now := time.Date(2020, 12, 3, 8, 0, 0, 0, time.UTC)
+	require.Equal(t, now.Add(-24*time.Hour), Source{TimePeriod: "day", Now: now}.Cutoff())
+	require.Equal(t, now.Add(-3*time.Hour), Source{TimePeriod: "day", Window: 3 * time.Hour, Now: now}.Cutoff())
+	// The previous digest is left to the providers which use it
+	require.Equal(t, now.Add(-24*time.Hour), Source{TimePeriod: "day", Since: now.Add(-time.Hour), Now: now}.Cutoff())
+	require.True(t, Source{TimePeriod: "all"}.Cutoff().IsZero())
+}
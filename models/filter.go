@@ -0,0 +1,213 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter is a set of rules stories must pass to be included in a digest. It
+// can be set for a feed, and for each source. Unset rules always pass
+type Filter struct {
+	MinScore    int `toml:"min_score"`
+	MinComments int `toml:"min_comments"`
+	// MinUpvoteRatio is the lowest fraction of votes which are upvotes, from 0 to 1
+	MinUpvoteRatio float64 `toml:"min_upvote_ratio"`
+	// Include and Exclude are case-insensitive regular expressions, matched
+	// against the title and text, each on their own lines so ^ and $ anchor
+	// to either. A plain keyword works too
+	Include []string `toml:"include"`
+	Exclude []string `toml:"exclude"`
+	// AllowDomains and DenyDomains match link domains, including subdomains
+	AllowDomains []string `toml:"allow_domains"`
+	DenyDomains  []string `toml:"deny_domains"`
+	// IncludeFlairs and ExcludeFlairs match flair text, ignoring case
+	IncludeFlairs []string `toml:"include_flairs"`
+	ExcludeFlairs []string `toml:"exclude_flairs"`
+	// The Exclude switches are pointers, so a source can turn off one its
+	// feed turns on by setting it to false
+	ExcludeNSFW     *bool `toml:"exclude_nsfw"`
+	ExcludeSpoilers *bool `toml:"exclude_spoilers"`
+	ExcludeStickied *bool `toml:"exclude_stickied"`
+}
+
+// FilterItem is what a Filter knows about a story
+type FilterItem struct {
+	Title       string
+	Text        string
+	Domain      string
+	Flair       string
+	Score       int
+	NumComments int
+	UpvoteRatio float64
+	NSFW        bool
+	Spoiler     bool
+	Stickied    bool
+}
+
+// IsEmpty reports whether the filter has no rules, so everything passes
+func (f Filter) IsEmpty() bool {
+	return f.MinScore == 0 && f.MinComments == 0 && f.MinUpvoteRatio == 0 &&
+		len(f.Include) == 0 && len(f.Exclude) == 0 &&
+		len(f.AllowDomains) == 0 && len(f.DenyDomains) == 0 &&
+		len(f.IncludeFlairs) == 0 && len(f.ExcludeFlairs) == 0 &&
+		!isTrue(f.ExcludeNSFW) && !isTrue(f.ExcludeSpoilers) && !isTrue(f.ExcludeStickied)
+}
+
+// Validate checks the regular expressions compile, and the ratio is in range
+func (f Filter) Validate() error {
+	_, err := f.Matcher()
+	if err != nil {
+		return err
+	}
+	if f.MinUpvoteRatio < 0 || f.MinUpvoteRatio > 1 {
+		return fmt.Errorf("invalid MinUpvoteRatio %v, expected 0 to 1", f.MinUpvoteRatio)
+	}
+
+	return nil
+}
+
+// Merge returns the filter, with any unset rules taken from defaults
+func (f Filter) Merge(defaults Filter) Filter {
+	if f.MinScore == 0 {
+		f.MinScore = defaults.MinScore
+	}
+	if f.MinComments == 0 {
+		f.MinComments = defaults.MinComments
+	}
+	if f.MinUpvoteRatio == 0 {
+		f.MinUpvoteRatio = defaults.MinUpvoteRatio
+	}
+	if len(f.Include) == 0 {
+		f.Include = defaults.Include
+	}
+	if len(f.Exclude) == 0 {
+		f.Exclude = defaults.Exclude
+	}
+	if len(f.AllowDomains) == 0 {
+		f.AllowDomains = defaults.AllowDomains
+	}
+	if len(f.DenyDomains) == 0 {
+		f.DenyDomains = defaults.DenyDomains
+	}
+	if len(f.IncludeFlairs) == 0 {
+		f.IncludeFlairs = defaults.IncludeFlairs
+	}
+	if len(f.ExcludeFlairs) == 0 {
+		f.ExcludeFlairs = defaults.ExcludeFlairs
+	}
+	if f.ExcludeNSFW == nil {
+		f.ExcludeNSFW = defaults.ExcludeNSFW
+	}
+	if f.ExcludeSpoilers == nil {
+		f.ExcludeSpoilers = defaults.ExcludeSpoilers
+	}
+	if f.ExcludeStickied == nil {
+		f.ExcludeStickied = defaults.ExcludeStickied
+	}
+	return f
+}
+
+// FilterMatcher is a Filter with its patterns compiled, to match many items
+type FilterMatcher struct {
+	Filter
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// Matcher compiles the filter's patterns
+func (f Filter) Matcher() (FilterMatcher, error) {
+	m := FilterMatcher{Filter: f}
+	var err error
+	m.include, err = compilePatterns(f.Include)
+	if err != nil {
+		return m, err
+	}
+	m.exclude, err = compilePatterns(f.Exclude)
+	return m, err
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?im)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter pattern %q: %w", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// Match reports whether an item passes every rule
+func (m FilterMatcher) Match(item FilterItem) bool {
+	f := m.Filter
+	switch {
+	case item.Score < f.MinScore,
+		item.NumComments < f.MinComments,
+		item.UpvoteRatio < f.MinUpvoteRatio,
+		isTrue(f.ExcludeNSFW) && item.NSFW,
+		isTrue(f.ExcludeSpoilers) && item.Spoiler,
+		isTrue(f.ExcludeStickied) && item.Stickied:
+		return false
+	}
+
+	text := item.Title + "\n" + item.Text
+	if len(m.include) > 0 && !matchesAny(m.include, text) {
+		return false
+	}
+	if matchesAny(m.exclude, text) {
+		return false
+	}
+
+	if len(f.AllowDomains) > 0 && !matchesDomain(f.AllowDomains, item.Domain) {
+		return false
+	}
+	if matchesDomain(f.DenyDomains, item.Domain) {
+		return false
+	}
+
+	if len(f.IncludeFlairs) > 0 && !containsFold(f.IncludeFlairs, item.Flair) {
+		return false
+	}
+	if containsFold(f.ExcludeFlairs, item.Flair) {
+		return false
+	}
+
+	return true
+}
+
+// matchesAny reports whether s matches any of the patterns
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+// matchesDomain reports whether domain is any of domains, or a subdomain of one
+func matchesDomain(domains []string, domain string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(domain), "www.")
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.ToLower(d), "www.")
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(s []string, e string) bool {
+	for _, v := range s {
+		if strings.EqualFold(v, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Match(t *testing.T) {
+	item := FilterItem{
+		Title:       "Go 1.16 is released",
+		Text:        "With embed support",
+		Domain:      "blog.golang.org",
+		Flair:       "News",
+		Score:       120,
+		NumComments: 30,
+		UpvoteRatio: 0.95,
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty", Filter{}, true},
+		{"min score", Filter{MinScore: 200}, false},
+		{"min comments", Filter{MinComments: 30}, true},
+		{"upvote ratio", Filter{MinUpvoteRatio: 0.97}, false},
+		{"include keyword", Filter{Include: []string{"EMBED"}}, true},
+		{"include regex", Filter{Include: []string{`go 2\.\d+`}}, false},
+		{"exclude", Filter{Exclude: []string{"release"}}, false},
+		{"allow subdomain", Filter{AllowDomains: []string{"golang.org"}}, true},
+		{"allow other", Filter{AllowDomains: []string{"go.dev"}}, false},
+		{"deny", Filter{DenyDomains: []string{"www.golang.org"}}, false},
+		{"include flair", Filter{IncludeFlairs: []string{"news"}}, true},
+		{"exclude flair", Filter{ExcludeFlairs: []string{"Meta"}}, true},
+		{"exclude nsfw", Filter{ExcludeNSFW: boolPtr(true)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.filter.Matcher()
+			require.NoError(t, err)
+			require.Equal(t, tt.want, m.Match(item))
+		})
+	}
+
+	item.Stickied = true
+	m, err := Filter{ExcludeStickied: boolPtr(true)}.Matcher()
+	require.NoError(t, err)
+	require.False(t, m.Match(item))
+
+	// The title and text are matched together
+	m, err = Filter{Include: []string{`released\nwith`}}.Matcher()
+	require.NoError(t, err)
+	require.True(t, m.Match(item))
+	// but anchors match the end of the title, as well as the text
+	m, err = Filter{Include: []string{`released$`}}.Matcher()
+	require.NoError(t, err)
+	require.True(t, m.Match(item))
+	m, err = Filter{Exclude: []string{`\?$`}}.Matcher()
+	require.NoError(t, err)
+	require.False(t, m.Match(FilterItem{Title: "Which editor?", Text: "I use vim"}))
+
+	_, err = Filter{Exclude: []string{"["}}.Matcher()
+	require.Error(t, err)
+}
+
+func TestFilter_Merge(t *testing.T) {
+	feed := Filter{MinScore: 10, Exclude: []string{"meta"}, ExcludeNSFW: boolPtr(true), ExcludeSpoilers: boolPtr(true)}
+	got := Filter{MinScore: 50, Exclude: []string{}, ExcludeSpoilers: boolPtr(false)}.Merge(feed)
+
+	require.Equal(t, 50, got.MinScore)
+	require.Equal(t, []string{"meta"}, got.Exclude)
+	require.True(t, *got.ExcludeNSFW)
+	// Sources can turn off switches the feed turns on
+	require.False(t, *got.ExcludeSpoilers)
+	require.True(t, Filter{}.Merge(Filter{}).IsEmpty())
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
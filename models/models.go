@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -12,6 +14,8 @@ import (
 
 // Story is a single piece of content (post, link, etc.)
 type Story struct {
+	// ID identifies the story to its source, e.g. a reddit fullname like t3_abc
+	ID           string
 	Title        string
 	Link         string
 	Hostname     string
@@ -19,14 +23,85 @@ type Story struct {
 	NumComments  int
 	Subreddit    string
 	Text         string
+	// Author is set for short posts which are shown by author instead of title
+	Author string
+	Score  int
+	// Image is a thumbnail or preview image URL
+	Image    string
+	Duration time.Duration
+	// Enclosure is a media file URL, e.g. a podcast episode
+	Enclosure string
+	Comments  []Comment
+	// Discussions are other places the same link was posted
+	Discussions []Discussion
+	// Source is the title of the block the story came from, set when it's
+	// shown in a merged block
+	Source string
+}
+
+// Discussion is where a link was posted, e.g. a subreddit
+type Discussion struct {
+	Source       string
+	CommentsLink string
+	NumComments  int
+}
+
+// TotalComments is the number of comments on the story and its Discussions
+func (s Story) TotalComments() int {
+	total := s.NumComments
+	for _, d := range s.Discussions {
+		total += d.NumComments
+	}
+	return total
+}
+
+// Keys identify a story across digests and sources, by its ID and its link.
+// Stories with neither, like posts without a link, fall back to their
+// discussion's link
+func (s Story) Keys() []string {
+	var keys []string
+	if s.ID != "" {
+		keys = append(keys, "id:"+s.ID)
+	}
+	if s.Link != "" {
+		keys = append(keys, "link:"+s.LinkKey())
+	}
+	if len(keys) == 0 && s.CommentsLink != "" {
+		keys = append(keys, "comments:"+s.CommentsLink)
+	}
+	return keys
+}
+
+// LinkKey normalizes the story's link, so variations of the same URL are equal
+func (s Story) LinkKey() string {
+	u, err := url.Parse(s.Link)
+	if err != nil || u.Host == "" {
+		return s.Link
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	return host + strings.TrimSuffix(u.EscapedPath(), "/") + "?" + u.RawQuery
+}
+
+// Comment is a reply to a story
+type Comment struct {
+	Author string
+	Text   string
+	Score  int
+	Link   string
 }
 
 // Block is a collection of stories. In the future, a digest may have multiple blocks.
 type Block struct {
-	Title   string
+	Title string
+	// Type is the type of source the stories came from, e.g. "reddit", or
+	// BlockTypeMixed. Blocks stored before types were added have none
+	Type    string
 	Stories []Story
 }
 
+// BlockTypeMixed is the Type of a block with stories from several types of source
+const BlockTypeMixed = "mixed"
+
 // ContentBlocks is a slice of Blocks
 type ContentBlocks []Block
 
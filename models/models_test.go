@@ -0,0 +1,16 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStory_Keys(t *testing.T) {
+	require.Equal(t, []string{"id:t3_a", "link:example.com/post?"},
+		Story{ID: "t3_a", Link: "https://www.example.com/post/", CommentsLink: "https://reddit.com/a"}.Keys())
+	require.Equal(t, []string{"link:example.com/post?"}, Story{Link: "https://example.com/post"}.Keys())
+	require.Equal(t, []string{"comments:https://example.social/@gopher/3"},
+		Story{CommentsLink: "https://example.social/@gopher/3"}.Keys())
+	require.Empty(t, Story{Text: "Nothing to go on"}.Keys())
+}
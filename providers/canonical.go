@@ -0,0 +1,152 @@
+package providers
+
+import (
+	"net/url"
+	"strings"
+)
+
+// trackingParams are query parameters which only track where a click came from
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"ref_src": true,
+	"ref_url": true,
+	"_ga":     true,
+	"amp":     true,
+}
+
+// redirectParams are redirect services, and the parameter with their destination
+var redirectParams = map[string]string{
+	"out.reddit.com":        "url",
+	"google.com/url":        "q",
+	"l.facebook.com/l.php":  "u",
+	"lm.facebook.com/l.php": "u",
+	"youtube.com/redirect":  "q",
+	"t.umblr.com/redirect":  "z",
+	"href.li/":              "",
+}
+
+// maxRedirects limits how many redirects are unwrapped from a link
+const maxRedirects = 3
+
+// canonicalURL returns the link without tracking parameters, redirects, AMP
+// or mobile versions of the page. A www. prefix is kept, since not every site
+// serves both, but it's ignored when comparing links. Unparseable links are
+// returned as they are
+func canonicalURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+
+	for i := 0; i < maxRedirects; i++ {
+		target, ok := unwrapRedirect(u)
+		if !ok {
+			break
+		}
+		u = target
+	}
+
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "https" && u.Port() == "443") || (u.Scheme == "http" && u.Port() == "80") {
+		u.Host = u.Hostname()
+	}
+	u = unwrapAMP(u)
+
+	host := u.Hostname()
+	rest := host[strings.Index(host, ".")+1:]
+	switch {
+	case (strings.HasPrefix(host, "m.") || strings.HasPrefix(host, "mobile.") || strings.HasPrefix(host, "amp.")) &&
+		strings.Contains(rest, "."):
+		// The rest must still be a domain, not just a TLD like mobile.de's
+		u.Host = rest
+	case strings.Contains(host, ".m."):
+		// e.g. en.m.wikipedia.org
+		u.Host = strings.Replace(host, ".m.", ".", 1)
+	case host == "youtu.be" && len(u.Path) > 1:
+		q := u.Query()
+		q.Set("v", strings.TrimPrefix(u.Path, "/"))
+		u = &url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/watch", RawQuery: q.Encode()}
+	}
+
+	q := u.Query()
+	removed := false
+	for param := range q {
+		if trackingParams[param] || strings.HasPrefix(param, "utm_") {
+			q.Del(param)
+			removed = true
+		}
+	}
+	// Re-encoding changes the order of parameters, so only do it if necessary
+	if removed {
+		u.RawQuery = q.Encode()
+	}
+	// Hashbang fragments are part of the page's address
+	if !strings.HasPrefix(u.Fragment, "!") {
+		u.Fragment = ""
+	}
+
+	return u.String()
+}
+
+// unwrapRedirect returns the destination of a link to a redirect service
+func unwrapRedirect(u *url.URL) (*url.URL, bool) {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	param, ok := redirectParams[host]
+	if !ok {
+		param, ok = redirectParams[host+u.Path]
+	}
+	if !ok {
+		return nil, false
+	}
+
+	// Some services put the destination after the ? with no parameter name
+	dest := u.RawQuery
+	if param != "" {
+		dest = u.Query().Get(param)
+	}
+	target, err := url.Parse(dest)
+	if err != nil || target.Host == "" {
+		return nil, false
+	}
+	return target, true
+}
+
+// unwrapAMP returns the original page of an AMP link
+func unwrapAMP(u *url.URL) *url.URL {
+	host := u.Hostname()
+	var cached string
+	switch {
+	case strings.HasSuffix(host, ".cdn.ampproject.org"):
+		// e.g. example-com.cdn.ampproject.org/c/s/example.com/story
+		cached = strings.TrimPrefix(strings.TrimPrefix(u.Path, "/v"), "/c")
+	case strings.TrimPrefix(host, "www.") == "google.com" && strings.HasPrefix(u.Path, "/amp/"):
+		cached = strings.TrimPrefix(u.Path, "/amp")
+	}
+	if cached != "" {
+		scheme := "http"
+		if strings.HasPrefix(cached, "/s/") {
+			scheme = "https"
+			cached = strings.TrimPrefix(cached, "/s")
+		}
+		target, err := url.Parse(scheme + ":/" + cached)
+		if err == nil && target.Host != "" {
+			target.RawQuery = u.RawQuery
+			u = target
+		}
+	}
+
+	if strings.HasSuffix(u.Path, "/amp") || strings.HasSuffix(u.Path, "/amp/") {
+		u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/amp")
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		u.RawPath = ""
+	}
+	return u
+}
@@ -0,0 +1,39 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_canonicalURL(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://www.example.com/story?utm_source=rss&utm_medium=feed&id=4", "https://www.example.com/story?id=4"},
+		{"https://example.com/story?fbclid=abc#comments", "https://example.com/story"},
+		{"https://example.com/app#!/story/1", "https://example.com/app#!/story/1"},
+		{"https://Example.com:443/b?z=1&a=2", "https://example.com/b?z=1&a=2"},
+		{"https://m.youtube.com/watch?v=abc", "https://youtube.com/watch?v=abc"},
+		{"https://youtu.be/abc?t=30", "https://www.youtube.com/watch?t=30&v=abc"},
+		{"https://en.m.wikipedia.org/wiki/Go", "https://en.wikipedia.org/wiki/Go"},
+		{"https://amp.theguardian.com/world/story", "https://theguardian.com/world/story"},
+		{"https://mobile.de/auto/1", "https://mobile.de/auto/1"},
+		{"https://amp.dev/documentation/", "https://amp.dev/documentation/"},
+		{"https://m.me/gopher", "https://m.me/gopher"},
+		{"https://mobile.twitter.com/golang/status/1", "https://twitter.com/golang/status/1"},
+		{"https://www.example.com/news/story/amp/", "https://www.example.com/news/story"},
+		{"https://www-example-com.cdn.ampproject.org/c/s/www.example.com/story", "https://www.example.com/story"},
+		{"https://www.google.com/amp/s/example.com/story", "https://example.com/story"},
+		{"https://out.reddit.com/t3_abc?url=https%3A%2F%2Fexample.com%2Fstory%3Futm_source%3Dreddit&token=x", "https://example.com/story"},
+		{"https://www.google.com/url?q=https://example.com/story&sa=D", "https://example.com/story"},
+		{"https://href.li/?https://example.com/story", "https://example.com/story"},
+		{"not a url", "not a url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			require.Equal(t, tt.want, canonicalURL(tt.link))
+		})
+	}
+}
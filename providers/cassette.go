@@ -0,0 +1,273 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrCassetteMiss means a replayed request wasn't in the cassette
+var ErrCassetteMiss = errors.New("request not recorded in cassette")
+
+// redactedParams are query parameters holding credentials, which aren't
+// written to cassettes. Request headers aren't recorded at all
+var redactedParams = []string{"key", "access_token", "client_secret"}
+
+// accessTokenPattern matches tokens issued in responses, e.g. by reddit
+var accessTokenPattern = regexp.MustCompile(`"access_token"\s*:\s*"[^"]*"`)
+
+// timeValuePattern matches dates and Unix times in query parameters, like
+// the cutoffs providers filter by, which change with the time of the request
+var timeValuePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}(T[\d:.]+(Z|[+-]\d{2}:\d{2})?)?|\b\d{10}\b`)
+
+const redacted = "REDACTED"
+
+// Cassette records the HTTP exchanges of providers to a file, or replays
+// them from one, so a digest can be generated again without the network.
+// Pass it to providers with WithCassette. Replays are only deterministic if
+// digests are created at RecordedAt, since sources filter by time
+type Cassette struct {
+	path   string
+	replay bool
+
+	mu   sync.Mutex
+	file cassetteFile
+	used []bool
+}
+
+// cassetteFile is what a cassette is saved as
+type cassetteFile struct {
+	RecordedAt   time.Time             `json:"recorded_at"`
+	Interactions []cassetteInteraction `json:"interactions"`
+}
+
+// cassetteInteraction is a request and the response it got
+type cassetteInteraction struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	RequestBody  string      `json:"request_body,omitempty"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	ResponseBody string      `json:"response_body"`
+}
+
+// RecordCassette creates a cassette which records to path, recorded now. The
+// file is rewritten after every exchange, so it's complete even if the process dies
+func RecordCassette(path string) *Cassette {
+	return &Cassette{path: path, file: cassetteFile{RecordedAt: time.Now().Round(time.Second)}}
+}
+
+// LoadCassette loads a recorded cassette to replay. Requests are matched by
+// method, URL and body, in the order they were recorded, or if none match
+// exactly, ignoring dates and times in the query. Once a request's
+// recordings are used up, the last of them is replayed again
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	c := &Cassette{path: path, replay: true}
+	err = json.Unmarshal(data, &c.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cassette %s: %w", path, err)
+	}
+	c.used = make([]bool, len(c.file.Interactions))
+
+	return c, nil
+}
+
+// RecordedAt is when recording started
+func (c *Cassette) RecordedAt() time.Time {
+	return c.file.RecordedAt
+}
+
+// WithCassette records or replays a provider's HTTP requests with c. Nil
+// cassettes are ignored
+func WithCassette(c *Cassette) Option {
+	return func(o *clientOptions) {
+		if c != nil {
+			o.cassette = c
+		}
+	}
+}
+
+// client returns a copy of base which sends requests through the cassette
+func (c *Cassette) client(base *http.Client) *http.Client {
+	client := *base
+	next := base.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = cassetteTransport{cassette: c, next: next}
+	return &client
+}
+
+type cassetteTransport struct {
+	cassette *Cassette
+	next     http.RoundTripper
+}
+
+// RoundTrip replays a recorded response, or sends the request and records it
+func (t cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	method, rawURL := req.Method, redactURL(req.URL)
+
+	if t.cassette.replay {
+		it, ok := t.cassette.find(method, rawURL, string(reqBody))
+		if !ok {
+			return nil, fmt.Errorf("%w: %s %s", ErrCassetteMiss, method, rawURL)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", it.Status, http.StatusText(it.Status)),
+			StatusCode:    it.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        it.Header.Clone(),
+			Body:          ioutil.NopCloser(bytes.NewReader([]byte(it.ResponseBody))),
+			ContentLength: int64(len(it.ResponseBody)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	err = t.cassette.record(cassetteInteraction{
+		Method:       method,
+		URL:          rawURL,
+		RequestBody:  string(reqBody),
+		Status:       resp.StatusCode,
+		Header:       header,
+		ResponseBody: accessTokenPattern.ReplaceAllString(string(respBody), `"access_token":"`+redacted+`"`),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record cassette: %w", err)
+	}
+
+	return resp, nil
+}
+
+// find returns the first unused interaction matching the request, or the
+// last used one if they're all used. Exact matches are preferred over ones
+// which only differ by the dates and times in their query
+func (c *Cassette) find(method, rawURL, body string) (cassetteInteraction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	it, ok := c.match(func(it cassetteInteraction) bool {
+		return it.Method == method && it.URL == rawURL && it.RequestBody == body
+	})
+	if ok {
+		return it, true
+	}
+
+	loose := looseURL(rawURL)
+	return c.match(func(it cassetteInteraction) bool {
+		return it.Method == method && it.RequestBody == body && looseURL(it.URL) == loose
+	})
+}
+
+// looseURL returns the URL without dates and times in its query
+func looseURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	for param, values := range q {
+		for i, v := range values {
+			values[i] = timeValuePattern.ReplaceAllString(v, "")
+		}
+		q[param] = values
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// match returns the first unused interaction for which matches is true, or
+// the last used one. The caller must hold mu
+func (c *Cassette) match(matches func(cassetteInteraction) bool) (cassetteInteraction, bool) {
+	last := -1
+	for i, it := range c.file.Interactions {
+		if !matches(it) {
+			continue
+		}
+		if !c.used[i] {
+			c.used[i] = true
+			return it, true
+		}
+		last = i
+	}
+	if last < 0 {
+		return cassetteInteraction{}, false
+	}
+	return c.file.Interactions[last], true
+}
+
+// record adds an interaction and writes the cassette
+func (c *Cassette) record(it cassetteInteraction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.file.Interactions = append(c.file.Interactions, it)
+	data, err := json.MarshalIndent(c.file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a crash can't leave half a cassette
+	tmp := c.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// redactURL returns the URL with credentials in its query replaced
+func redactURL(u *url.URL) string {
+	q := u.Query()
+	removed := false
+	for _, param := range redactedParams {
+		if q.Get(param) != "" {
+			q.Set(param, redacted)
+			removed = true
+		}
+	}
+	if !removed {
+		return u.String()
+	}
+
+	redactedURL := *u
+	redactedURL.RawQuery = q.Encode()
+	return redactedURL.String()
+}
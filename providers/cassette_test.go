@@ -0,0 +1,114 @@
+package providers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/hebo/mailshine/providers/reddittest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCassette(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "reddit.json")
+
+	fake := reddittest.NewServer()
+	fake.AddPosts(
+		reddittest.Post{ID: "a", Subreddit: "games", Title: "Patch notes", URL: "https://example.com/patch", Score: 500},
+		reddittest.Post{ID: "b", Subreddit: "games", Title: "Screenshot Saturday", Score: 200,
+			Comments: []reddittest.Comment{{Author: "dev", Body: "Mine", Score: 12}}},
+	)
+	src := models.Source{Type: models.SourceTypeReddit, Name: "games", NumItems: 5, TimePeriod: "day", Comments: 1}
+	newClient := func(c *Cassette) *RedditClient {
+		client, err := NewRedditClient(reddittest.ClientID, reddittest.ClientSecret, WithCassette(c),
+			WithBaseURL(fake.URL), WithTokenURL(fake.TokenURL()), WithWebURL(fake.URL))
+		require.NoError(t, err)
+		return client
+	}
+
+	recorded, err := newClient(RecordCassette(path)).Fetch(src)
+	require.NoError(t, err)
+	require.Len(t, recorded[0].Stories, 2)
+	fake.Close()
+
+	cassette, err := LoadCassette(path)
+	require.NoError(t, err)
+	// The token, the listing and the comments of each post
+	require.Len(t, cassette.file.Interactions, 4)
+	require.Contains(t, cassette.file.Interactions[0].ResponseBody, `"access_token":"REDACTED"`)
+
+	// Replaying works without the server, and more than once
+	client := newClient(cassette)
+	for i := 0; i < 2; i++ {
+		replayed, err := client.Fetch(src)
+		require.NoError(t, err)
+		require.Equal(t, recorded, replayed)
+	}
+
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeReddit, Name: "news", NumItems: 5, TimePeriod: "day"})
+	require.True(t, errors.Is(err, ErrCassetteMiss))
+}
+
+func TestCassette_hackerNews(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hn.json")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hits": [{"objectID": "1", "title": "A link", "url": "https://example.com/post", "points": 50}]}`))
+	}))
+	recorder := RecordCassette(path)
+	src := models.Source{Type: models.SourceTypeHackerNews, Name: "story", NumItems: 5, TimePeriod: "day", Now: recorder.RecordedAt()}
+	recorded, err := NewHackerNewsClient(WithCassette(recorder), WithBaseURL(ts.URL+"/")).Fetch(src)
+	require.NoError(t, err)
+	ts.Close()
+
+	cassette, err := LoadCassette(path)
+	require.NoError(t, err)
+	require.Equal(t, recorder.RecordedAt().Unix(), cassette.RecordedAt().Unix())
+	client := NewHackerNewsClient(WithCassette(cassette), WithBaseURL(ts.URL+"/"))
+
+	// The request has the same cutoff at the time it was recorded, and its
+	// cutoff is ignored at any other time
+	for _, now := range []time.Time{cassette.RecordedAt(), time.Now().Add(72 * time.Hour)} {
+		src.Now = now
+		replayed, err := client.Fetch(src)
+		require.NoError(t, err)
+		require.Equal(t, recorded, replayed)
+	}
+}
+
+func Test_looseURL(t *testing.T) {
+	require.Equal(t, "https://hn.algolia.com/api/v1/search?hitsPerPage=5&numericFilters=created_at_i%3E&tags=story",
+		looseURL("https://hn.algolia.com/api/v1/search?hitsPerPage=5&numericFilters=created_at_i%3E1607000000&tags=story"))
+	require.Equal(t, "https://api.github.com/search/repositories?q=created%3A%3E+language%3Ago",
+		looseURL("https://api.github.com/search/repositories?q=created%3A%3E2020-12-01+language%3Ago"))
+}
+
+func Test_redactURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://api.stackexchange.com/2.3/questions?site=unix&key=secret", "https://api.stackexchange.com/2.3/questions?key=REDACTED&site=unix"},
+		{"https://example.com/feed?b=2&a=1", "https://example.com/feed?b=2&a=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, redactURL(u))
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+)
+
+// NewFeedClient creates a new FeedClient
+func NewFeedClient(opts ...Option) *FeedClient {
+	return &FeedClient{newClientOptions("", opts)}
+}
+
+// FeedClient reads RSS 2.0, Atom and JSON Feed documents
+type FeedClient struct {
+	clientOptions
+}
+
+// Feed is a syndication feed, in any of the supported formats
+type Feed struct {
+	Title   string
+	Entries []FeedEntry
+}
+
+// FeedEntry is a single item in a Feed
+type FeedEntry struct {
+	ID        string
+	Title     string
+	Link      string
+	Content   string
+	Published time.Time
+	// Image is a thumbnail or other image for the entry
+	Image    string
+	Duration time.Duration
+	// Enclosure is an attached media file, e.g. a podcast episode
+	Enclosure string
+}
+
+// FetchFeed fetches and parses the feed at feedURL
+func (f *FeedClient) FetchFeed(feedURL string) (Feed, error) {
+	log.Printf("Making request to %q\n", feedURL)
+	resp, err := f.get(feedURL, nil)
+	if err != nil {
+		return Feed{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Feed{}, err
+	}
+
+	feed, err := ParseFeed(body)
+	if err != nil {
+		return feed, err
+	}
+
+	log.Printf("Fetched %d entries from %q", len(feed.Entries), feedURL)
+	return feed, nil
+}
+
+// Fetch fetches the newest entries of a feed as a single Block. Only entries
+// published within the source's time period, and after the previous digest,
+// are included
+func (f *FeedClient) Fetch(src models.Source) ([]models.Block, error) {
+	feed, err := f.FetchFeed(src.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	title := src.Title
+	if title == "" {
+		title = feed.Title
+	}
+
+	since := src.Cutoff()
+	if src.Since.After(since) {
+		since = src.Since
+	}
+	entries := feed.Since(since)
+	if len(entries) > src.NumItems {
+		entries = entries[:src.NumItems]
+	}
+
+	block := models.Block{Title: title}
+	for _, entry := range entries {
+		story := entry.ToStory()
+		story.ID = entry.StoryID(src.Name)
+		block.Stories = append(block.Stories, story)
+	}
+	return []models.Block{block}, nil
+}
+
+var _ Provider = &FeedClient{}
+
+// Since returns the entries published after t, newest first. Entries without
+// a publish date are skipped, since there's no telling if they're new
+func (f Feed) Since(t time.Time) []FeedEntry {
+	entries := []FeedEntry{}
+	for _, entry := range f.Entries {
+		if entry.Published.After(t) {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Published.After(entries[j].Published)
+	})
+	return entries
+}
+
+// ToStory converts to a story
+func (e FeedEntry) ToStory() models.Story {
+	linkURL, err := url.Parse(e.Link)
+	if err != nil {
+		log.Printf("Failed to parse Link %q: %s", e.Link, err)
+		linkURL = &url.URL{}
+	}
+
+	return models.Story{
+		Title:     e.Title,
+		Link:      linkURL.String(),
+		Hostname:  linkURL.Host,
+		Text:      htmlToMarkdown(e.Content),
+		Image:     e.Image,
+		Duration:  e.Duration,
+		Enclosure: e.Enclosure,
+	}
+}
+
+// StoryID identifies the entry across digests, by its ID within the feed at
+// feedURL. Atom entries without a published time are dated by when they were
+// last updated, so an edited entry can look new, but its ID stays the same
+// and dedupe_digests skips it
+func (e FeedEntry) StoryID(feedURL string) string {
+	if e.ID == "" {
+		return ""
+	}
+	return feedURL + "#" + e.ID
+}
+
+// ParseFeed parses an RSS 2.0, Atom or JSON Feed document
+func ParseFeed(body []byte) (Feed, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		return parseJSONFeed(body)
+	}
+
+	return parseXMLFeed(body)
+}
+
+type xmlFeed struct {
+	XMLName xml.Name
+	// RSS
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	// Atom
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	GUID           string `xml:"guid"`
+	Title          string `xml:"title"`
+	Link           string `xml:"link"`
+	Description    string `xml:"description"`
+	ContentEncoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PubDate        string `xml:"pubDate"`
+	Enclosure      struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	ITunesDuration string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	ITunesSummary  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	ITunesImage    struct {
+		Href string `xml:"href,attr"`
+	} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	mediaElements
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"http://www.w3.org/2005/Atom content"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	mediaElements
+}
+
+// mediaElements are Media RSS elements, which may also be wrapped in a group
+type mediaElements struct {
+	Thumbnails []struct {
+		URL   string `xml:"url,attr"`
+		Width int    `xml:"width,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	Contents []struct {
+		URL      string `xml:"url,attr"`
+		Medium   string `xml:"medium,attr"`
+		Duration int    `xml:"duration,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ content"`
+	Description string         `xml:"http://search.yahoo.com/mrss/ description"`
+	Group       *mediaElements `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+// all returns the elements merged with those in their group
+func (m mediaElements) all() mediaElements {
+	if m.Group == nil {
+		return m
+	}
+
+	g := *m.Group
+	g.Thumbnails = append(g.Thumbnails, m.Thumbnails...)
+	g.Contents = append(g.Contents, m.Contents...)
+	if g.Description == "" {
+		g.Description = m.Description
+	}
+	return g
+}
+
+// image returns the largest thumbnail, or the first image content
+func (m mediaElements) image() string {
+	m = m.all()
+	image, width := "", -1
+	for _, t := range m.Thumbnails {
+		if t.Width > width {
+			image, width = t.URL, t.Width
+		}
+	}
+	if image != "" {
+		return image
+	}
+
+	for _, c := range m.Contents {
+		if c.Medium == "image" {
+			return c.URL
+		}
+	}
+	return ""
+}
+
+// duration returns the duration of the first content which has one
+func (m mediaElements) duration() time.Duration {
+	for _, c := range m.all().Contents {
+		if c.Duration > 0 {
+			return time.Duration(c.Duration) * time.Second
+		}
+	}
+	return 0
+}
+
+func parseXMLFeed(body []byte) (Feed, error) {
+	doc := xmlFeed{}
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	// Feeds declaring other charsets are usually ASCII compatible in practice
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	err := dec.Decode(&doc)
+	if err != nil {
+		return Feed{}, fmt.Errorf("failed to parse feed: %w", err)
+	}
+
+	feed := Feed{}
+	switch doc.XMLName.Local {
+	case "rss":
+		feed.Title = strings.TrimSpace(doc.Channel.Title)
+		for _, item := range doc.Channel.Items {
+			content := item.ContentEncoded
+			if content == "" {
+				content = item.Description
+			}
+			if content == "" {
+				content = html.EscapeString(item.ITunesSummary)
+			}
+			if content == "" {
+				content = html.EscapeString(item.all().Description)
+			}
+			image := item.image()
+			if image == "" {
+				image = item.ITunesImage.Href
+			}
+			duration := item.duration()
+			if duration == 0 {
+				duration = parseITunesDuration(item.ITunesDuration)
+			}
+			id := item.GUID
+			if id == "" {
+				id = item.Link
+			}
+			feed.Entries = append(feed.Entries, FeedEntry{
+				ID:        strings.TrimSpace(id),
+				Title:     strings.TrimSpace(item.Title),
+				Link:      strings.TrimSpace(item.Link),
+				Content:   content,
+				Published: parseFeedTime(item.PubDate),
+				Image:     image,
+				Duration:  duration,
+				Enclosure: strings.TrimSpace(item.Enclosure.URL),
+			})
+		}
+	case "feed":
+		feed.Title = strings.TrimSpace(doc.Title)
+		for _, entry := range doc.Entries {
+			content := entry.Content
+			if content == "" {
+				content = entry.Summary
+			}
+			if content == "" {
+				content = html.EscapeString(entry.all().Description)
+			}
+			published := parseFeedTime(entry.Published)
+			if published.IsZero() {
+				published = parseFeedTime(entry.Updated)
+			}
+			feed.Entries = append(feed.Entries, FeedEntry{
+				ID:        strings.TrimSpace(entry.ID),
+				Title:     strings.TrimSpace(entry.Title),
+				Link:      entry.alternateLink(),
+				Content:   content,
+				Published: published,
+				Image:     entry.image(),
+				Duration:  entry.duration(),
+			})
+		}
+	default:
+		return feed, fmt.Errorf("unsupported feed format %q", doc.XMLName.Local)
+	}
+
+	return feed, nil
+}
+
+// alternateLink returns the entry's link to its web page
+func (e atomEntry) alternateLink() string {
+	for _, l := range e.Links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return strings.TrimSpace(l.Href)
+		}
+	}
+	if len(e.Links) > 0 {
+		return strings.TrimSpace(e.Links[0].Href)
+	}
+	return ""
+}
+
+type jsonFeed struct {
+	Title string `json:"title"`
+	Items []struct {
+		ID            interface{} `json:"id"`
+		URL           string      `json:"url"`
+		ExternalURL   string      `json:"external_url"`
+		Title         string      `json:"title"`
+		ContentHTML   string      `json:"content_html"`
+		ContentText   string      `json:"content_text"`
+		Summary       string      `json:"summary"`
+		DatePublished string      `json:"date_published"`
+		DateModified  string      `json:"date_modified"`
+	} `json:"items"`
+}
+
+func parseJSONFeed(body []byte) (Feed, error) {
+	doc := jsonFeed{}
+	err := json.Unmarshal(body, &doc)
+	if err != nil {
+		return Feed{}, fmt.Errorf("failed to parse feed: %w", err)
+	}
+
+	feed := Feed{Title: doc.Title}
+	for _, item := range doc.Items {
+		link := item.URL
+		if link == "" {
+			link = item.ExternalURL
+		}
+
+		// content_text is plain text, so escape it like HTML would be
+		content := item.ContentHTML
+		if content == "" {
+			content = html.EscapeString(item.ContentText)
+		}
+		if content == "" {
+			content = html.EscapeString(item.Summary)
+		}
+
+		published := parseFeedTime(item.DatePublished)
+		if published.IsZero() {
+			published = parseFeedTime(item.DateModified)
+		}
+
+		// IDs are required, but may be numbers, or missing from sloppy feeds
+		id := item.URL
+		if item.ID != nil {
+			id = fmt.Sprint(item.ID)
+		}
+
+		feed.Entries = append(feed.Entries, FeedEntry{
+			ID:        id,
+			Title:     item.Title,
+			Link:      link,
+			Content:   content,
+			Published: published,
+		})
+	}
+
+	return feed, nil
+}
+
+// parseITunesDuration parses durations given as seconds, "MM:SS" or
+// "HH:MM:SS", returning zero if it can't
+func parseITunesDuration(s string) time.Duration {
+	var d time.Duration
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		d = d*60 + time.Duration(n)
+	}
+	return d * time.Second
+}
+
+var feedTimeLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon, 02 Jan 2006 15:04 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseFeedTime parses the many date formats found in feeds, returning the
+// zero time if none match
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedTimeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,122 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"rss", `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0"><channel><title>Blog</title>
+<item><title>Old</title><link>https://example.com/old</link><pubDate>Mon, 02 Nov 2020 10:00:00 +0000</pubDate></item>
+<item><title>New</title><link>https://example.com/new</link><description>&lt;p&gt;Hi&lt;/p&gt;</description><pubDate>Tue, 03 Nov 2020 10:00:00 GMT</pubDate></item>
+</channel></rss>`},
+		{"atom", `<feed xmlns="http://www.w3.org/2005/Atom"><title>Blog</title>
+<entry><title>Old</title><link href="https://example.com/old"/><updated>2020-11-02T10:00:00Z</updated></entry>
+<entry><title>New</title><link rel="alternate" href="https://example.com/new"/><content type="html">&lt;p&gt;Hi&lt;/p&gt;</content><published>2020-11-03T10:00:00Z</published></entry>
+</feed>`},
+		{"json", `{"version": "https://jsonfeed.org/version/1.1", "title": "Blog", "items": [
+{"id": "1", "title": "Old", "url": "https://example.com/old", "date_published": "2020-11-02T10:00:00Z"},
+{"id": "2", "title": "New", "url": "https://example.com/new", "content_html": "<p>Hi</p>", "date_published": "2020-11-03T10:00:00Z"}
+]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := ParseFeed([]byte(tt.body))
+			require.NoError(t, err)
+			require.Equal(t, "Blog", feed.Title)
+			require.Len(t, feed.Entries, 2)
+
+			entries := feed.Since(time.Date(2020, 11, 2, 12, 0, 0, 0, time.UTC))
+			require.Len(t, entries, 1)
+			story := entries[0].ToStory()
+			require.Equal(t, "New", story.Title)
+			require.Equal(t, "https://example.com/new", story.Link)
+			require.Equal(t, "example.com", story.Hostname)
+			require.Equal(t, "Hi", story.Text)
+		})
+	}
+}
+
+func TestFeedClient_Fetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<feed xmlns="http://www.w3.org/2005/Atom"><title>Blog</title>
+<entry><id>tag:example.com,2020:1</id><title>Edited</title><link href="https://example.com/1"/>
+<published>2020-10-01T10:00:00Z</published><updated>2020-12-02T10:00:00Z</updated></entry>
+<entry><id>tag:example.com,2020:2</id><title>Undated</title><link href="https://example.com/2"/>
+<updated>2020-12-02T11:00:00Z</updated></entry>
+</feed>`))
+	}))
+	defer ts.Close()
+
+	src := models.Source{Type: models.SourceTypeFeed, Name: ts.URL, NumItems: 5, TimePeriod: "week",
+		Now: time.Date(2020, 12, 3, 8, 0, 0, 0, time.UTC)}
+	blocks, err := NewFeedClient().Fetch(src)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+
+	// Entries are dated by when they were published, and identified by their
+	// ID, so dedupe_digests skips them if they're edited later
+	require.Equal(t, []string{"Undated"}, storyTitles(blocks[0]))
+	require.Equal(t, ts.URL+"#tag:example.com,2020:2", blocks[0].Stories[0].ID)
+	require.Contains(t, blocks[0].Stories[0].Keys(), "id:"+ts.URL+"#tag:example.com,2020:2")
+
+	// Entries from before the previous digest were already sent
+	src.Since = time.Date(2020, 12, 2, 12, 0, 0, 0, time.UTC)
+	blocks, err = NewFeedClient().Fetch(src)
+	require.NoError(t, err)
+	require.Empty(t, blocks[0].Stories)
+}
+
+func TestParseFeed_jsonIDs(t *testing.T) {
+	feed, err := ParseFeed([]byte(`{"version": "https://jsonfeed.org/version/1.1", "title": "Blog", "items": [
+{"id": 7, "title": "Numbered"},
+{"title": "Linked", "url": "https://example.com/linked"},
+{"title": "Neither"}
+]}`))
+	require.NoError(t, err)
+	require.Len(t, feed.Entries, 3)
+	require.Equal(t, "7", feed.Entries[0].ID)
+	require.Equal(t, "https://example.com/linked", feed.Entries[1].ID)
+	require.Equal(t, "", feed.Entries[2].ID)
+	require.Equal(t, "", feed.Entries[2].StoryID("https://example.com/feed.json"))
+}
+
+func TestParseFeed_media(t *testing.T) {
+	body := `<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/"><channel>
+<title>Talks</title>
+<item><title>Talk</title><link>https://talks.example.com/1</link>
+<pubDate>Tue, 03 Nov 2020 10:00:00 +0000</pubDate>
+<media:group>
+	<media:content url="https://talks.example.com/1.mp4" type="video/mp4" medium="video" duration="125"/>
+	<media:thumbnail url="https://talks.example.com/1-small.jpg" width="120"/>
+	<media:thumbnail url="https://talks.example.com/1.jpg" width="480"/>
+	<media:description>Watch &lt;this&gt;</media:description>
+</media:group>
+</item></channel></rss>`
+
+	feed, err := ParseFeed([]byte(body))
+	require.NoError(t, err)
+	require.Len(t, feed.Entries, 1)
+
+	story := feed.Entries[0].ToStory()
+	require.Equal(t, "https://talks.example.com/1.jpg", story.Image)
+	require.Equal(t, 125*time.Second, story.Duration)
+	require.Equal(t, "Watch &lt;this&gt;", story.Text)
+}
+
+func Test_parseITunesDuration(t *testing.T) {
+	require.Equal(t, 90*time.Second, parseITunesDuration("90"))
+	require.Equal(t, 4*time.Minute+5*time.Second, parseITunesDuration("04:05"))
+	require.Equal(t, time.Hour+2*time.Minute+3*time.Second, parseITunesDuration("1:02:03"))
+	require.Equal(t, time.Duration(0), parseITunesDuration("soon"))
+}
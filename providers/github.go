@@ -0,0 +1,268 @@
+package providers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+)
+
+const (
+	githubAPIURL = "https://api.github.com/"
+	// githubTrending is the source name prefix for trending repositories,
+	// optionally followed by a language, e.g. "trending:go"
+	githubTrending = "trending"
+)
+
+// NewGitHubClient creates a new GitHubClient. The token is optional, but
+// raises GitHub's rate limits
+func NewGitHubClient(token string, opts ...Option) *GitHubClient {
+	return &GitHubClient{
+		clientOptions: newClientOptions(githubAPIURL, opts),
+		token:         token,
+	}
+}
+
+// GitHubClient interfaces with the GitHub REST API
+type GitHubClient struct {
+	clientOptions
+	token string
+}
+
+// GitHubRelease is a published release of a repository
+type GitHubRelease struct {
+	HTMLURL     string    `json:"html_url"`
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Body        string    `json:"body"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+
+	// Repo is the repository as "owner/repo", which isn't part of the API response
+	Repo string `json:"-"`
+}
+
+// GitHubRepo is a repository from search results
+type GitHubRepo struct {
+	FullName        string `json:"full_name"`
+	HTMLURL         string `json:"html_url"`
+	Description     string `json:"description"`
+	Language        string `json:"language"`
+	StargazersCount int    `json:"stargazers_count"`
+}
+
+func (g *GitHubClient) header() http.Header {
+	header := http.Header{"Accept": {"application/vnd.github.v3+json"}}
+	if g.token != "" {
+		header.Set("Authorization", "token "+g.token)
+	}
+	return header
+}
+
+// FetchReleases fetches releases of a repository, given as "owner/repo",
+// published after since
+func (g *GitHubClient) FetchReleases(repo string, since time.Time) ([]GitHubRelease, error) {
+	owner, name := splitRepo(repo)
+	if owner == "" || name == "" {
+		return nil, fmt.Errorf("invalid repository %q, expected owner/repo", repo)
+	}
+
+	releasesURL := fmt.Sprintf("%srepos/%s/%s/releases?per_page=30",
+		g.baseURL, url.PathEscape(owner), url.PathEscape(name))
+	log.Printf("Making request to %q\n", releasesURL)
+
+	var res []GitHubRelease
+	err := g.getJSON(releasesURL, g.header(), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []GitHubRelease
+	for _, release := range res {
+		if release.Draft || !release.PublishedAt.After(since) {
+			continue
+		}
+		release.Repo = repo
+		releases = append(releases, release)
+	}
+
+	log.Printf("Fetched %d releases from %q", len(releases), repo)
+	return releases, nil
+}
+
+// FetchTrending fetches the most starred repositories created after since,
+// optionally limited to a language
+func (g *GitHubClient) FetchTrending(language string, since time.Time, numRepos int) ([]GitHubRepo, error) {
+	query := "created:>" + since.UTC().Format("2006-01-02")
+	if language != "" {
+		query += " language:" + language
+	}
+
+	params := url.Values{}
+	params.Add("q", query)
+	params.Add("sort", "stars")
+	params.Add("order", "desc")
+	params.Add("per_page", strconv.Itoa(numRepos))
+	searchURL := g.baseURL + "search/repositories?" + params.Encode()
+	log.Printf("Making request to %q\n", searchURL)
+
+	res := struct {
+		Items []GitHubRepo `json:"items"`
+	}{}
+	err := g.getJSON(searchURL, g.header(), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Fetched %d trending repositories", len(res.Items))
+	return res.Items, nil
+}
+
+// Fetch fetches new releases of each repository in the source, as one block
+// per repository or a single merged block. Sources named "trending" fetch
+// popular new repositories instead
+func (g *GitHubClient) Fetch(src models.Source) ([]models.Block, error) {
+	if src.Name == githubTrending || strings.HasPrefix(src.Name, githubTrending+":") {
+		return g.fetchTrending(src)
+	}
+
+	var blocks []models.Block
+	var merged []GitHubRelease
+	for _, repo := range src.AllNames() {
+		releases, err := g.FetchReleases(repo, src.Cutoff())
+		if err != nil {
+			return nil, fmt.Errorf("fetch releases %q: %w", repo, err)
+		}
+
+		if src.Merge {
+			merged = append(merged, releases...)
+			continue
+		}
+
+		var stories []models.Story
+		for _, release := range releases {
+			stories = append(stories, release.ToStory())
+		}
+		if len(stories) > src.NumItems {
+			stories = stories[:src.NumItems]
+		}
+
+		// Most repositories won't have a release in any given digest
+		if len(stories) == 0 {
+			continue
+		}
+		title := src.Title
+		if title == "" {
+			title = repo
+		}
+		blocks = append(blocks, models.Block{Title: title, Stories: stories})
+	}
+
+	if src.Merge {
+		blocks = append(blocks, mergeReleases(merged, src.Title, src.NumItems))
+	}
+	return blocks, nil
+}
+
+// mergeReleases returns a block of the newest n releases, across repositories
+func mergeReleases(releases []GitHubRelease, title string, n int) models.Block {
+	if title == "" {
+		title = "Releases"
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].PublishedAt.After(releases[j].PublishedAt)
+	})
+	if len(releases) > n {
+		releases = releases[:n]
+	}
+
+	block := models.Block{Title: title}
+	for _, release := range releases {
+		block.Stories = append(block.Stories, release.ToStory())
+	}
+	return block
+}
+
+func (g *GitHubClient) fetchTrending(src models.Source) ([]models.Block, error) {
+	language := strings.TrimPrefix(strings.TrimPrefix(src.Name, githubTrending), ":")
+	repos, err := g.FetchTrending(language, src.Cutoff(), src.NumItems)
+	if err != nil {
+		return nil, fmt.Errorf("fetch trending: %w", err)
+	}
+
+	title := src.Title
+	if title == "" {
+		title = "Trending on GitHub"
+		if language != "" {
+			title = fmt.Sprintf("Trending %s on GitHub", language)
+		}
+	}
+
+	block := models.Block{Title: title}
+	for _, repo := range repos {
+		block.Stories = append(block.Stories, repo.ToStory())
+	}
+	return []models.Block{block}, nil
+}
+
+var _ Provider = &GitHubClient{}
+
+// ToStory converts to a story
+func (r GitHubRelease) ToStory() models.Story {
+	title := r.Name
+	if title == "" {
+		title = r.TagName
+	}
+	if !strings.Contains(title, r.TagName) {
+		title = fmt.Sprintf("%s (%s)", title, r.TagName)
+	}
+	if r.Prerelease {
+		title += " [pre-release]"
+	}
+
+	linkURL, err := url.Parse(r.HTMLURL)
+	if err != nil {
+		log.Printf("Failed to parse Link %q: %s", r.HTMLURL, err)
+		linkURL = &url.URL{}
+	}
+
+	return models.Story{
+		Title:    r.Repo + " " + title,
+		Link:     linkURL.String(),
+		Hostname: linkURL.Host,
+		Text:     sanitizeMarkdown(r.Body),
+	}
+}
+
+// ToStory converts to a story
+func (r GitHubRepo) ToStory() models.Story {
+	linkURL, err := url.Parse(r.HTMLURL)
+	if err != nil {
+		log.Printf("Failed to parse Link %q: %s", r.HTMLURL, err)
+		linkURL = &url.URL{}
+	}
+
+	return models.Story{
+		Title:    r.FullName,
+		Link:     linkURL.String(),
+		Hostname: linkURL.Host,
+		Score:    r.StargazersCount,
+		Text:     textToMarkdown(r.Description),
+	}
+}
+
+// splitRepo splits "owner/repo" into its parts
+func splitRepo(s string) (string, string) {
+	parts := strings.Split(strings.Trim(s, "/"), "/")
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
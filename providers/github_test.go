@@ -0,0 +1,84 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubClient_Fetch(t *testing.T) {
+	now := time.Date(2020, 12, 3, 8, 0, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "token secret", r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/repos/golang/go/releases":
+			w.Write([]byte(`[
+				{"html_url": "https://github.com/golang/go/releases/go1.16", "tag_name": "go1.16", "name": "Go 1.16", "body": "Embed **files**<img src=x onerror=alert(1)>", "published_at": "2020-12-03T06:00:00Z"},
+				{"html_url": "https://github.com/golang/go/releases/go1.16rc1", "tag_name": "go1.16rc1", "prerelease": true, "published_at": "2020-12-02T12:00:00Z"},
+				{"tag_name": "go1.17", "draft": true, "published_at": "2020-12-03T07:00:00Z"},
+				{"tag_name": "go1.15", "published_at": "2020-11-01T00:00:00Z"}
+			]`))
+		case "/repos/hebo/mailshine/releases":
+			w.Write([]byte(`[
+				{"html_url": "https://github.com/hebo/mailshine/releases/v1", "tag_name": "v1", "name": "First", "published_at": "2020-12-03T07:00:00Z"}
+			]`))
+		case "/search/repositories":
+			require.Equal(t, "created:>2020-12-02 language:go", r.URL.Query().Get("q"))
+			require.Equal(t, "2", r.URL.Query().Get("per_page"))
+			w.Write([]byte(`{"items": [
+				{"full_name": "a/fast", "html_url": "https://github.com/a/fast", "description": "Fast <things> & *stuff*", "stargazers_count": 900}
+			]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	gh := NewGitHubClient("secret", WithBaseURL(ts.URL+"/"))
+	src := models.Source{
+		Type:       models.SourceTypeGitHub,
+		Names:      []string{"golang/go", "hebo/mailshine"},
+		NumItems:   2,
+		TimePeriod: "day",
+		Now:        now,
+	}
+
+	blocks, err := gh.Fetch(src)
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+	require.Equal(t, "golang/go", blocks[0].Title)
+	require.Equal(t, []string{"golang/go Go 1.16 (go1.16)", "golang/go go1.16rc1 [pre-release]"}, storyTitles(blocks[0]))
+	require.Equal(t, "github.com", blocks[0].Stories[0].Hostname)
+	require.Equal(t, "Embed **files**", blocks[0].Stories[0].Text)
+
+	// Merged blocks have the newest releases of all repositories
+	src.Merge = true
+	blocks, err = gh.Fetch(src)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "Releases", blocks[0].Title)
+	require.Equal(t, []string{"hebo/mailshine First (v1)", "golang/go Go 1.16 (go1.16)"}, storyTitles(blocks[0]))
+
+	blocks, err = gh.Fetch(models.Source{Type: models.SourceTypeGitHub, Name: "trending:go", NumItems: 2, TimePeriod: "day", Now: now})
+	require.NoError(t, err)
+	require.Equal(t, "Trending go on GitHub", blocks[0].Title)
+	require.Equal(t, models.Story{
+		Title: "a/fast", Link: "https://github.com/a/fast", Hostname: "github.com", Score: 900, Text: `Fast &lt;things&gt; &amp; \*stuff\*`,
+	}, blocks[0].Stories[0])
+
+	_, err = gh.Fetch(models.Source{Type: models.SourceTypeGitHub, Name: "missing/repo", NumItems: 2, TimePeriod: "day"})
+	require.Error(t, err)
+}
+
+func storyTitles(b models.Block) []string {
+	var titles []string
+	for _, s := range b.Stories {
+		titles = append(titles, s.Title)
+	}
+	return titles
+}
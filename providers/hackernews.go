@@ -0,0 +1,130 @@
+package providers
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+)
+
+const (
+	hackerNewsAPIURL  = "https://hn.algolia.com/api/v1/"
+	hackerNewsItemURL = "https://news.ycombinator.com/item"
+)
+
+// hackerNewsTitles are the block titles for well known story tags
+var hackerNewsTitles = map[string]string{
+	"story":      "Hacker News",
+	"front_page": "Hacker News",
+	"ask_hn":     "Ask HN",
+	"show_hn":    "Show HN",
+}
+
+// NewHackerNewsClient creates a new HackerNewsClient
+func NewHackerNewsClient(opts ...Option) *HackerNewsClient {
+	return &HackerNewsClient{newClientOptions(hackerNewsAPIURL, opts)}
+}
+
+// HackerNewsClient interfaces with the Hacker News search API
+type HackerNewsClient struct {
+	clientOptions
+}
+
+// FetchStories fetches the highest ranked stories with the given tag, posted
+// after since. A zero since fetches stories from any time
+func (h *HackerNewsClient) FetchStories(tag string, since time.Time, numStories int) (HackerNewsSearchResponse, error) {
+	searchRes := HackerNewsSearchResponse{}
+
+	baseURL, err := url.Parse(h.baseURL)
+	if err != nil {
+		return searchRes, fmt.Errorf("malformed URL: %w", err)
+	}
+	baseURL.Path += "search"
+
+	params := url.Values{}
+	params.Add("tags", tag)
+	if !since.IsZero() {
+		params.Add("numericFilters", fmt.Sprintf("created_at_i>%d", since.Unix()))
+	}
+	params.Add("hitsPerPage", strconv.Itoa(numStories))
+	baseURL.RawQuery = params.Encode()
+
+	log.Printf("Making request to %q\n", baseURL.String())
+	err = h.getJSON(baseURL.String(), nil, &searchRes)
+	if err != nil {
+		return searchRes, err
+	}
+
+	log.Printf("Fetched %d stories from Hacker News %q", len(searchRes.Hits), tag)
+	return searchRes, nil
+}
+
+// Fetch fetches the top stories for a tag as a single Block
+func (h *HackerNewsClient) Fetch(src models.Source) ([]models.Block, error) {
+	res, err := h.FetchStories(src.Name, src.Cutoff(), src.NumItems)
+	if err != nil {
+		return nil, err
+	}
+
+	title, ok := hackerNewsTitles[src.Name]
+	if !ok {
+		title = "HN " + src.Name
+	}
+
+	return []models.Block{res.ToBlock(title)}, nil
+}
+
+var _ Provider = &HackerNewsClient{}
+
+// ToBlock converts to a block
+func (r HackerNewsSearchResponse) ToBlock(title string) models.Block {
+	block := models.Block{
+		Title: title,
+	}
+	for _, hit := range r.Hits {
+		commentsURL := hackerNewsItemURL + "?id=" + url.QueryEscape(hit.ObjectID)
+
+		// Ask HN and other text posts link to their own discussion
+		link := hit.URL
+		if link == "" {
+			link = commentsURL
+		}
+		linkURL, err := url.Parse(canonicalURL(link))
+		if err != nil {
+			log.Printf("Failed to parse Link %q: %s", link, err)
+			linkURL = &url.URL{}
+		}
+
+		block.Stories = append(block.Stories, models.Story{
+			ID:           "hackernews/" + hit.ObjectID,
+			Title:        hit.Title,
+			Link:         linkURL.String(),
+			Hostname:     linkURL.Host,
+			CommentsLink: commentsURL,
+			NumComments:  hit.NumComments,
+			Score:        hit.Points,
+			Text:         htmlToMarkdown(hit.StoryText),
+		})
+	}
+
+	return block
+}
+
+// HackerNewsSearchResponse is the response from the Hacker News search API
+type HackerNewsSearchResponse struct {
+	Hits []struct {
+		ObjectID    string `json:"objectID"`
+		Title       string `json:"title"`
+		URL         string `json:"url"`
+		Author      string `json:"author"`
+		Points      int    `json:"points"`
+		NumComments int    `json:"num_comments"`
+		StoryText   string `json:"story_text"`
+		CreatedAtI  int64  `json:"created_at_i"`
+	} `json:"hits"`
+	NbHits      int `json:"nbHits"`
+	HitsPerPage int `json:"hitsPerPage"`
+}
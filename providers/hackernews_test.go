@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHackerNewsClient_Fetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/search", r.URL.Path)
+		require.Equal(t, "ask_hn", r.URL.Query().Get("tags"))
+		require.Equal(t, "2", r.URL.Query().Get("hitsPerPage"))
+
+		w.Write([]byte(`{"hits": [
+			{"objectID": "1", "title": "Ask HN: Why?", "url": null, "num_comments": 12, "points": 40, "story_text": "<p>Because <script>alert(1)</script><b>reasons</b></p>"},
+			{"objectID": "2", "title": "A link", "url": "https://www.example.com/post", "num_comments": 3}
+		]}`))
+	}))
+	defer ts.Close()
+
+	hn := NewHackerNewsClient(WithBaseURL(ts.URL + "/api/v1/"))
+	blocks, err := hn.Fetch(models.Source{Type: models.SourceTypeHackerNews, Name: "ask_hn", NumItems: 2, TimePeriod: "day"})
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "Ask HN", blocks[0].Title)
+
+	stories := blocks[0].Stories
+	require.Len(t, stories, 2)
+	require.Equal(t, "hackernews/1", stories[0].ID)
+	require.Equal(t, "https://news.ycombinator.com/item?id=1", stories[0].Link)
+	require.Equal(t, stories[0].Link, stories[0].CommentsLink)
+	require.Equal(t, "Because reasons", stories[0].Text)
+	require.Equal(t, 12, stories[0].NumComments)
+	require.Equal(t, 40, stories[0].Score)
+	require.Equal(t, "www.example.com", stories[1].Hostname)
+}
@@ -0,0 +1,117 @@
+package providers
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlDropRE     = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlCommentRE  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlLinkRE     = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	htmlBreakRE    = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlBlockEndRE = regexp.MustCompile(`(?i)</(p|div|h[1-6]|blockquote|pre|ul|ol|table|tr)>`)
+	htmlListItemRE = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlTagRE      = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLinesRE   = regexp.MustCompile(`\n\s*\n\s*\n+`)
+	// markdownTagRE matches HTML tags, but not autolinks like <https://a.b>
+	markdownTagRE = regexp.MustCompile(`</?[a-zA-Z][a-zA-Z0-9-]*(\s[^>]*)?/?>`)
+	// linkEscaper escapes the characters which would end a link's URL early
+	linkEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+	textURLRE   = regexp.MustCompile(`https?://[^\s<>]+`)
+	// textEscaper escapes the characters markdown gives a meaning to
+	textEscaper = strings.NewReplacer(
+		`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "{", `\{`, "}", `\}`,
+		"[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "#", `\#`, "+", `\+`,
+		"-", `\-`, ".", `\.`, "!", `\!`, "|", `\|`, "~", `\~`,
+		"<", "&lt;", ">", "&gt;", "&", "&amp;",
+	)
+)
+
+// htmlToMarkdown converts untrusted HTML into markdown which is safe to render.
+// Links are kept, paragraphs and lists become line breaks, and every other tag
+// is dropped. Text is escaped, so the only markdown in the result is what's
+// added here
+func htmlToMarkdown(s string) string {
+	s = htmlDropRE.ReplaceAllString(s, "")
+	s = htmlCommentRE.ReplaceAllString(s, "")
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range htmlLinkRE.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(htmlBlockToMarkdown(s[last:loc[0]]))
+		href := html.UnescapeString(strings.TrimSpace(s[loc[2]:loc[3]]))
+		text := strings.TrimSpace(escapeText(htmlTagRE.ReplaceAllString(s[loc[4]:loc[5]], "")))
+		switch {
+		case !isWebURL(href):
+			b.WriteString(text)
+		case text == "":
+			b.WriteString("<" + linkEscaper.Replace(href) + ">")
+		default:
+			b.WriteString("[" + text + "](" + linkEscaper.Replace(href) + ")")
+		}
+		last = loc[1]
+	}
+	b.WriteString(htmlBlockToMarkdown(s[last:]))
+
+	return strings.TrimSpace(blankLinesRE.ReplaceAllString(b.String(), "\n\n"))
+}
+
+// htmlBlockToMarkdown converts HTML without links, turning paragraphs and
+// lists into line breaks and escaping the text between tags
+func htmlBlockToMarkdown(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range htmlTagRE.FindAllStringIndex(s, -1) {
+		b.WriteString(escapeText(s[last:loc[0]]))
+		tag := s[loc[0]:loc[1]]
+		switch {
+		case htmlBreakRE.MatchString(tag):
+			b.WriteString("\n")
+		case htmlBlockEndRE.MatchString(tag):
+			b.WriteString("\n\n")
+		case htmlListItemRE.MatchString(tag):
+			b.WriteString("\n- ")
+		}
+		last = loc[1]
+	}
+	b.WriteString(escapeText(s[last:]))
+	return b.String()
+}
+
+// sanitizeMarkdown drops the raw HTML from untrusted markdown, keeping its
+// formatting
+func sanitizeMarkdown(s string) string {
+	s = htmlDropRE.ReplaceAllString(s, "")
+	s = htmlCommentRE.ReplaceAllString(s, "")
+	s = markdownTagRE.ReplaceAllString(s, "")
+	return strings.TrimSpace(blankLinesRE.ReplaceAllString(s, "\n\n"))
+}
+
+// textToMarkdown converts plain text, which may have HTML entities, into
+// markdown which renders as the same text. URLs become links
+func textToMarkdown(s string) string {
+	return strings.TrimSpace(escapeText(s))
+}
+
+// escapeText unescapes HTML entities in s, then escapes it so it renders as
+// the same text in markdown. URLs become links
+func escapeText(s string) string {
+	s = html.UnescapeString(s)
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range textURLRE.FindAllStringIndex(s, -1) {
+		b.WriteString(textEscaper.Replace(s[last:loc[0]]))
+		b.WriteString("<" + s[loc[0]:loc[1]] + ">")
+		last = loc[1]
+	}
+	b.WriteString(textEscaper.Replace(s[last:]))
+	return b.String()
+}
+
+func isWebURL(s string) bool {
+	lower := strings.ToLower(s)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
@@ -0,0 +1,78 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/russross/blackfriday/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_htmlToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"paragraphs", "<p>One</p><p>Two<br>Three</p>", "One\n\nTwo\nThree"},
+		{"links", `<p>See <a href="https://example.com/a?b=1&amp;c=2" rel="nofollow">this</a></p>`, "See [this](https://example.com/a?b=1&c=2)"},
+		{"unsafe link", `<a href="javascript:alert(1)">click</a>`, "click"},
+		{"script", `<script>alert(1)</script>Hi`, "Hi"},
+		{"escaped tags", `&lt;script&gt;alert(1)&lt;/script&gt;`, `&lt;script&gt;alert\(1\)&lt;/script&gt;`},
+		{"entities", `Fish &amp; Chips &#8212; &quot;good&quot;`, `Fish &amp; Chips — "good"`},
+		{"lists", `<ul><li>a</li><li>b</li></ul>`, "- a\n- b"},
+		{"markdown in text", `<p>*Not bold* [x](javascript:alert(1))</p>`, `\*Not bold\* \[x\]\(javascript:alert\(1\)\)`},
+		{"bare link", `<a href="https://example.com/a b"></a>`, "<https://example.com/a%20b>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, htmlToMarkdown(tt.in))
+		})
+	}
+}
+
+func Test_htmlToMarkdownHostile(t *testing.T) {
+	// Whatever the input, the only HTML in the rendered result is what
+	// markdown itself produces, with links which came from anchors
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`[x](javascript:alert(1))`, "<p>[x](javascript:alert(1))</p>\n"},
+		{`&lt;img src=x onerror=alert(1)&gt;`, "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n"},
+		{`<img src=x onerror=alert(1)>Hi`, "<p>Hi</p>\n"},
+		{`&lt;a href="javascript:alert(1)"&gt;x&lt;/a&gt;`, "<p>&lt;a href=&ldquo;javascript:alert(1)&rdquo;&gt;x&lt;/a&gt;</p>\n"},
+		{`<a href="https://example.com/">[y](javascript:alert(1))</a>`,
+			`<p><a href="https://example.com/">[y](javascript:alert(1))</a></p>` + "\n"},
+		{`<a href="https://example.com/&quot; onclick=&quot;alert(1)">z</a>`,
+			`<p><a href="https://example.com/&quot;%20onclick=&quot;alert%281%29">z</a></p>` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			require.Equal(t, tt.want, string(blackfriday.Run([]byte(htmlToMarkdown(tt.in)))))
+		})
+	}
+}
+
+func Test_sanitizeMarkdown(t *testing.T) {
+	require.Equal(t, "## Fixes\n\n- **Crash** on <https://example.com/a>\n\nDone",
+		sanitizeMarkdown("## Fixes\n\n- **Crash** on <https://example.com/a>\n<script>alert(1)</script>\n<img src=x onerror=alert(1)>\n\n<!-- hidden -->\n<b>Done</b>"))
+	require.Equal(t, "1 < 2 and 3 > 2", sanitizeMarkdown("1 < 2 and 3 > 2"))
+}
+
+func Test_textToMarkdown(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"*Not bold* or _italic_", "<p>*Not bold* or _italic_</p>\n"},
+		{"#1 pick &amp; 2 < 3", "<p>#1 pick &amp; 2 &lt; 3</p>\n"},
+		{"- not a list [or link](x)", "<p>- not a list [or link](x)</p>\n"},
+		{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"See https://example.com/a_b_c!", `<p>See <a href="https://example.com/a_b_c!">https://example.com/a_b_c!</a></p>` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			require.Equal(t, tt.want, string(blackfriday.Run([]byte(textToMarkdown(tt.in)))))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	userAgent      = "mailshine/v0.1"
+	requestTimeout = 30 * time.Second
+)
+
+// Option configures a provider client
+type Option func(*clientOptions)
+
+// clientOptions holds the HTTP settings shared by provider clients
+type clientOptions struct {
+	baseURL    string
+	httpClient *http.Client
+	// tokenURL and webURL are only used by providers which need them, e.g. reddit
+	tokenURL string
+	webURL   string
+	// cassette records or replays requests, whichever client is set
+	cassette *Cassette
+}
+
+// WithBaseURL overrides the API base URL of a provider. Empty values are ignored
+func WithBaseURL(baseURL string) Option {
+	return func(o *clientOptions) {
+		if baseURL != "" {
+			o.baseURL = baseURL
+		}
+	}
+}
+
+// WithTokenURL overrides the OAuth token URL of a provider. Empty values are ignored
+func WithTokenURL(tokenURL string) Option {
+	return func(o *clientOptions) {
+		if tokenURL != "" {
+			o.tokenURL = tokenURL
+		}
+	}
+}
+
+// WithWebURL overrides the website a provider links discussions to. Empty
+// values are ignored
+func WithWebURL(webURL string) Option {
+	return func(o *clientOptions) {
+		if webURL != "" {
+			o.webURL = webURL
+		}
+	}
+}
+
+// WithHTTPClient sets the HTTP client used by a provider
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *clientOptions) {
+		o.httpClient = c
+	}
+}
+
+func newClientOptions(defaultBaseURL string, opts []Option) clientOptions {
+	o := clientOptions{
+		baseURL:    defaultBaseURL,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.cassette != nil {
+		o.httpClient = o.cassette.client(o.httpClient)
+	}
+
+	return o
+}
+
+// get issues a GET request and returns the response if it was successful.
+// The caller must close the response body
+func (o clientOptions) get(rawURL string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-agent", userAgent)
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %q from %s", resp.Status, rawURL)
+	}
+
+	return resp, nil
+}
+
+// getJSON issues a GET request and decodes the JSON response into v
+func (o clientOptions) getJSON(rawURL string, header http.Header, v interface{}) error {
+	resp, err := o.get(rawURL, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeJSON(resp.Body, v)
+}
+
+// decodeJSON decodes a JSON response body into v
+func decodeJSON(body io.Reader, v interface{}) error {
+	err := json.NewDecoder(body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,423 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+)
+
+const imapTimeout = time.Minute
+
+// NewIMAPClient creates a new IMAPClient for the server at addr, as host:port
+func NewIMAPClient(addr, username, password string, useTLS bool) (*IMAPClient, error) {
+	client := &IMAPClient{addr: addr, username: username, password: password, useTLS: useTLS}
+	if addr == "" || username == "" || password == "" {
+		return client, errors.New("missing IMAP address, username or password")
+	}
+
+	return client, nil
+}
+
+// IMAPClient reads newsletters from an IMAP mailbox
+type IMAPClient struct {
+	addr     string
+	username string
+	password string
+	useTLS   bool
+}
+
+// Newsletter is a single email message
+type Newsletter struct {
+	UID     string
+	Subject string
+	From    *mail.Address
+	Date    time.Time
+	// Body is the HTML body, or the escaped text body if there's no HTML
+	Body string
+}
+
+// FetchNewsletters fetches messages in a folder received after since,
+// optionally limited to some senders. Messages are left unread
+func (c *IMAPClient) FetchNewsletters(folder string, senders []string, since time.Time) ([]Newsletter, error) {
+	conn, err := c.open(folder)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	// SINCE only has a resolution of days, so dates are checked again below
+	criteria := "SINCE " + since.Format("2-Jan-2006")
+	if len(senders) > 0 {
+		criteria += " " + imapFromCriteria(senders)
+	}
+	responses, err := conn.cmd("UID SEARCH %s", criteria)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+
+	var uids []string
+	for _, res := range responses {
+		if strings.HasPrefix(res.line, "* SEARCH") {
+			uids = append(uids, strings.Fields(strings.TrimPrefix(res.line, "* SEARCH"))...)
+		}
+	}
+
+	var newsletters []Newsletter
+	for _, uid := range uids {
+		responses, err := conn.cmd("UID FETCH %s BODY.PEEK[]", uid)
+		if err != nil {
+			return nil, fmt.Errorf("fetch message %s: %w", uid, err)
+		}
+
+		for _, res := range responses {
+			if res.literal == nil {
+				continue
+			}
+			n, err := parseNewsletter(res.literal)
+			if err != nil {
+				log.Printf("Failed to parse message %s: %s", uid, err)
+				continue
+			}
+			if n.Date.Before(since) {
+				continue
+			}
+			n.UID = uid
+			newsletters = append(newsletters, n)
+		}
+	}
+
+	conn.cmd("LOGOUT")
+	log.Printf("Fetched %d newsletters from %q", len(newsletters), folder)
+	return newsletters, nil
+}
+
+// MarkNewslettersRead marks messages in a folder as read
+func (c *IMAPClient) MarkNewslettersRead(folder string, uids []string) error {
+	conn, err := c.open(folder)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	for _, uid := range uids {
+		_, err = conn.cmd(`UID STORE %s +FLAGS (\Seen)`, uid)
+		if err != nil {
+			return fmt.Errorf("mark message %s read: %w", uid, err)
+		}
+	}
+
+	conn.cmd("LOGOUT")
+	return nil
+}
+
+// open connects, logs in and selects a folder. The caller must close the connection
+func (c *IMAPClient) open(folder string) (*imapConn, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.cmd("LOGIN %s %s", imapQuote(c.username), imapQuote(c.password))
+	if err != nil {
+		conn.close()
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+	_, err = conn.cmd("SELECT %s", imapQuote(folder))
+	if err != nil {
+		conn.close()
+		return nil, fmt.Errorf("select %q: %w", folder, err)
+	}
+	return conn, nil
+}
+
+// Fetch fetches the newest newsletters in a folder as a single Block. Story
+// IDs are the folder and UID of their message
+func (c *IMAPClient) Fetch(src models.Source) ([]models.Block, error) {
+	newsletters, err := c.FetchNewsletters(src.Name, src.Senders, src.Cutoff())
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(newsletters, func(i, j int) bool {
+		return newsletters[i].Date.After(newsletters[j].Date)
+	})
+	if len(newsletters) > src.NumItems {
+		newsletters = newsletters[:src.NumItems]
+	}
+
+	title := src.Title
+	if title == "" {
+		title = src.Name
+	}
+	block := models.Block{Title: title}
+	for _, n := range newsletters {
+		story := n.ToStory()
+		story.ID = src.Name + "/" + n.UID
+		block.Stories = append(block.Stories, story)
+	}
+	return []models.Block{block}, nil
+}
+
+// MarkDelivered marks the messages of delivered stories as read, if the
+// source has MarkRead set
+func (c *IMAPClient) MarkDelivered(src models.Source, stories []models.Story) error {
+	if !src.MarkRead {
+		return nil
+	}
+
+	var uids []string
+	for _, story := range stories {
+		if strings.HasPrefix(story.ID, src.Name+"/") {
+			uids = append(uids, strings.TrimPrefix(story.ID, src.Name+"/"))
+		}
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+	return c.MarkNewslettersRead(src.Name, uids)
+}
+
+var _ DeliveryMarker = &IMAPClient{}
+
+// ToStory converts to a story
+func (n Newsletter) ToStory() models.Story {
+	story := models.Story{
+		Title: n.Subject,
+		Text:  htmlToMarkdown(n.Body),
+	}
+	if n.From != nil {
+		story.Hostname = n.From.Name
+		if story.Hostname == "" {
+			story.Hostname = n.From.Address
+		}
+	}
+	return story
+}
+
+// imapFromCriteria matches messages from any of the senders
+func imapFromCriteria(senders []string) string {
+	criteria := "FROM " + imapQuote(senders[len(senders)-1])
+	for i := len(senders) - 2; i >= 0; i-- {
+		criteria = fmt.Sprintf("OR FROM %s %s", imapQuote(senders[i]), criteria)
+	}
+	return criteria
+}
+
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// imapConn is a connection speaking just enough IMAP4rev1 to read a mailbox
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapResponse is an untagged response line, with any literal it contained
+type imapResponse struct {
+	line    string
+	literal []byte
+}
+
+var imapLiteralRE = regexp.MustCompile(`\{(\d+)\}$`)
+
+func (c *IMAPClient) dial() (*imapConn, error) {
+	dialer := &net.Dialer{Timeout: imapTimeout}
+	var conn net.Conn
+	var err error
+	if c.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(imapTimeout))
+
+	ic := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := ic.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting.line, "* OK") && !strings.HasPrefix(greeting.line, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", greeting.line)
+	}
+
+	return ic, nil
+}
+
+func (ic *imapConn) close() error {
+	return ic.conn.Close()
+}
+
+// cmd sends a command and returns its untagged responses once it completes
+func (ic *imapConn) cmd(format string, args ...interface{}) ([]imapResponse, error) {
+	ic.tag++
+	tag := "a" + strconv.Itoa(ic.tag)
+	_, err := fmt.Fprintf(ic.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		res, err := ic.readResponse()
+		if err != nil {
+			return responses, err
+		}
+		if strings.HasPrefix(res.line, tag+" ") {
+			status := strings.TrimPrefix(res.line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return responses, fmt.Errorf("server responded %q", status)
+			}
+			return responses, nil
+		}
+		responses = append(responses, res)
+	}
+}
+
+// readResponse reads a response line, including any literals within it
+func (ic *imapConn) readResponse() (imapResponse, error) {
+	res := imapResponse{}
+	for {
+		line, err := ic.r.ReadString('\n')
+		if err != nil {
+			return res, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		res.line += line
+
+		m := imapLiteralRE.FindStringSubmatch(line)
+		if m == nil {
+			return res, nil
+		}
+		size, _ := strconv.Atoi(m[1])
+		literal := make([]byte, size)
+		_, err = io.ReadFull(ic.r, literal)
+		if err != nil {
+			return res, err
+		}
+		res.literal = append(res.literal, literal...)
+	}
+}
+
+// parseNewsletter parses a raw email message
+func parseNewsletter(raw []byte) (Newsletter, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return Newsletter{}, err
+	}
+
+	dec := mime.WordDecoder{}
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	n := Newsletter{Subject: subject}
+	n.From, _ = mail.ParseAddress(msg.Header.Get("From"))
+	n.Date, _ = msg.Header.Date()
+
+	htmlBody, textBody := messageBody(msg.Header.Get("Content-Type"),
+		msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	n.Body = htmlBody
+	if n.Body == "" {
+		n.Body = strings.ReplaceAll(html.EscapeString(textBody), "\n", "<br>")
+	}
+	return n, nil
+}
+
+// messageBody returns the first HTML and plain text bodies in a message part
+func messageBody(contentType, encoding string, body io.Reader) (string, string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	body = transferDecoder(encoding, body)
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var htmlBody, textBody string
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			h, t := messageBody(part.Header.Get("Content-Type"),
+				part.Header.Get("Content-Transfer-Encoding"), part)
+			if htmlBody == "" {
+				htmlBody = h
+			}
+			if textBody == "" {
+				textBody = t
+			}
+		}
+		return htmlBody, textBody
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", ""
+	}
+	switch mediaType {
+	case "text/html":
+		return string(data), ""
+	case "text/plain":
+		return "", string(data)
+	}
+	return "", ""
+}
+
+// transferDecoder decodes a Content-Transfer-Encoding
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// newlineStripper removes line breaks, which base64 bodies are wrapped with
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		count, err := n.r.Read(p)
+		out := 0
+		for _, b := range p[:count] {
+			if b != '\r' && b != '\n' {
+				p[out] = b
+				out++
+			}
+		}
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
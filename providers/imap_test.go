@@ -0,0 +1,132 @@
+package providers
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIMAPServer serves a single connection with one message, recording commands
+func fakeIMAPServer(t *testing.T, message string) (string, <-chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	commands := make(chan []string, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var received []string
+		defer func() { commands <- received }()
+
+		fmt.Fprint(conn, "* OK IMAP4rev1 ready\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			received = append(received, line)
+
+			parts := strings.SplitN(line, " ", 3)
+			tag, cmd := parts[0], parts[1]
+			if cmd == "UID" {
+				cmd += " " + strings.Fields(parts[2])[0]
+			}
+			switch cmd {
+			case "UID SEARCH":
+				fmt.Fprint(conn, "* SEARCH 7\r\n")
+			case "UID FETCH":
+				fmt.Fprintf(conn, "* 1 FETCH (UID 7 BODY[] {%d}\r\n%s)\r\n", len(message), message)
+			case "LOGOUT":
+				fmt.Fprint(conn, "* BYE\r\n")
+				fmt.Fprintf(conn, "%s OK done\r\n", tag)
+				return
+			}
+			fmt.Fprintf(conn, "%s OK done\r\n", tag)
+		}
+	}()
+
+	return ln.Addr().String(), commands
+}
+
+func TestIMAPClient_Fetch(t *testing.T) {
+	date := time.Now().Add(-time.Hour).Format(time.RFC1123Z)
+	message := strings.Join([]string{
+		"From: Weekly News <news@example.com>",
+		"Subject: =?utf-8?q?Issue_=E2=84=961?=",
+		"Date: " + date,
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/alternative; boundary="b1"`,
+		"",
+		"--b1",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"Plain version",
+		"--b1",
+		"Content-Type: text/html; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		`<p>Read <a href=3D"https://example.com/story">the story</a></p>`,
+		"--b1--",
+		"",
+	}, "\r\n")
+	addr, commands := fakeIMAPServer(t, message)
+
+	client, err := NewIMAPClient(addr, "user", `pa"ss`, false)
+	require.NoError(t, err)
+
+	blocks, err := client.Fetch(models.Source{
+		Type:       models.SourceTypeIMAP,
+		Name:       "Newsletters",
+		NumItems:   5,
+		TimePeriod: "day",
+		Senders:    []string{"news@example.com", "other@example.com"},
+		MarkRead:   true,
+	})
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "Newsletters", blocks[0].Title)
+	require.Len(t, blocks[0].Stories, 1)
+
+	story := blocks[0].Stories[0]
+	require.Equal(t, "Newsletters/7", story.ID)
+	require.Equal(t, "Issue №1", story.Title)
+	require.Equal(t, "Weekly News", story.Hostname)
+	require.Equal(t, "Read [the story](https://example.com/story)", story.Text)
+
+	received := <-commands
+	require.Equal(t, `a1 LOGIN "user" "pa\"ss"`, received[0])
+	require.Equal(t, `a2 SELECT "Newsletters"`, received[1])
+	require.Contains(t, received[2], `OR FROM "news@example.com" FROM "other@example.com"`)
+	require.Equal(t, `a5 LOGOUT`, received[4])
+
+	// Only delivered messages are marked read
+	addr, commands = fakeIMAPServer(t, message)
+	client, err = NewIMAPClient(addr, "user", "pass", false)
+	require.NoError(t, err)
+	src := models.Source{Type: models.SourceTypeIMAP, Name: "Newsletters", MarkRead: true}
+	err = client.MarkDelivered(src, []models.Story{{ID: "Newsletters/7"}, {ID: "Other/8"}})
+	require.NoError(t, err)
+	received = <-commands
+	require.Equal(t, []string{
+		`a1 LOGIN "user" "pass"`,
+		`a2 SELECT "Newsletters"`,
+		`a3 UID STORE 7 +FLAGS (\Seen)`,
+		`a4 LOGOUT`,
+	}, received)
+
+	src.MarkRead = false
+	require.NoError(t, client.MarkDelivered(src, []models.Story{{ID: "Newsletters/7"}}))
+}
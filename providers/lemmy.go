@@ -0,0 +1,138 @@
+package providers
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hebo/mailshine/models"
+)
+
+// lemmySorts maps time periods to Lemmy's top sort types
+var lemmySorts = map[string]string{
+	"hour":  "TopHour",
+	"day":   "TopDay",
+	"week":  "TopWeek",
+	"month": "TopMonth",
+	"year":  "TopYear",
+	"all":   "TopAll",
+}
+
+// NewLemmyClient creates a new LemmyClient. Instances are taken from each
+// source's name, unless a base URL is given, which is then used for all of them
+func NewLemmyClient(opts ...Option) *LemmyClient {
+	return &LemmyClient{newClientOptions("", opts)}
+}
+
+// LemmyClient interfaces with Lemmy instances
+type LemmyClient struct {
+	clientOptions
+}
+
+// LemmyListingResponse is the response from a community's post listing
+type LemmyListingResponse struct {
+	Posts []struct {
+		Post struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+			URL  string `json:"url"`
+			Body string `json:"body"`
+			NSFW bool   `json:"nsfw"`
+		} `json:"post"`
+		Counts struct {
+			Score    int `json:"score"`
+			Comments int `json:"comments"`
+		} `json:"counts"`
+	} `json:"posts"`
+}
+
+func (l *LemmyClient) instanceURL(instance string) string {
+	if l.baseURL != "" {
+		return strings.TrimSuffix(l.baseURL, "/")
+	}
+	return "https://" + instance
+}
+
+// FetchCommunity fetches the top posts of a community, given as "community@instance"
+func (l *LemmyClient) FetchCommunity(name, period string, numStories int) (LemmyListingResponse, error) {
+	listingRes := LemmyListingResponse{}
+	community, instance := splitAt(strings.TrimPrefix(name, "!"))
+	if community == "" || instance == "" {
+		return listingRes, fmt.Errorf("invalid community %q, expected community@instance", name)
+	}
+
+	sort, ok := lemmySorts[period]
+	if !ok {
+		return listingRes, fmt.Errorf("unsupported time period %q", period)
+	}
+
+	params := url.Values{}
+	params.Add("community_name", community)
+	params.Add("sort", sort)
+	params.Add("limit", strconv.Itoa(numStories))
+	listURL := l.instanceURL(instance) + "/api/v3/post/list?" + params.Encode()
+
+	log.Printf("Making request to %q\n", listURL)
+	err := l.getJSON(listURL, nil, &listingRes)
+	if err != nil {
+		return listingRes, err
+	}
+
+	log.Printf("Fetched %d posts from %q", len(listingRes.Posts), name)
+	return listingRes, nil
+}
+
+// Fetch fetches the top posts of a community as a single Block
+func (l *LemmyClient) Fetch(src models.Source) ([]models.Block, error) {
+	listing, err := l.FetchCommunity(src.Name, src.TimePeriod, src.NumItems)
+	if err != nil {
+		return nil, err
+	}
+
+	title := src.Title
+	if title == "" {
+		title = "!" + strings.TrimPrefix(src.Name, "!")
+	}
+
+	_, instance := splitAt(strings.TrimPrefix(src.Name, "!"))
+	return []models.Block{listing.ToBlock(title, l.instanceURL(instance))}, nil
+}
+
+var _ Provider = &LemmyClient{}
+
+// ToBlock converts to a block, linking discussions to the given instance
+func (r LemmyListingResponse) ToBlock(title, instanceURL string) models.Block {
+	block := models.Block{
+		Title: title,
+	}
+	for _, p := range r.Posts {
+		commentsURL := fmt.Sprintf("%s/post/%d", instanceURL, p.Post.ID)
+
+		// Text posts link to their own discussion
+		link := p.Post.URL
+		if link == "" {
+			link = commentsURL
+		}
+		linkURL, err := url.Parse(canonicalURL(link))
+		if err != nil {
+			log.Printf("Failed to parse Link %q: %s", link, err)
+			linkURL = &url.URL{}
+		}
+
+		// Bodies are markdown from any instance federating with this one, so
+		// may have raw HTML
+		block.Stories = append(block.Stories, models.Story{
+			Title:        p.Post.Name,
+			Link:         linkURL.String(),
+			Hostname:     linkURL.Host,
+			CommentsLink: commentsURL,
+			NumComments:  p.Counts.Comments,
+			Score:        p.Counts.Score,
+			Text:         sanitizeMarkdown(p.Post.Body),
+		})
+	}
+
+	return block
+}
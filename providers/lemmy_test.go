@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLemmyClient_Fetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v3/post/list", r.URL.Path)
+		q := r.URL.Query()
+		require.Equal(t, "golang", q.Get("community_name"))
+		require.Equal(t, "TopWeek", q.Get("sort"))
+		require.Equal(t, "2", q.Get("limit"))
+
+		w.Write([]byte(`{"posts": [
+			{"post": {"id": 1, "name": "Go 1.16", "url": "https://go.dev/blog/go1.16?utm_source=lemmy"}, "counts": {"score": 40, "comments": 3}},
+			{"post": {"id": 2, "name": "Help with generics", "body": "How do I **start**?<script>alert(1)</script><img src=x onerror=alert(1)>"}, "counts": {"score": 8, "comments": 12}}
+		]}`))
+	}))
+	defer ts.Close()
+
+	client := NewLemmyClient(WithBaseURL(ts.URL))
+	blocks, err := client.Fetch(models.Source{Type: models.SourceTypeLemmy, Name: "!golang@lemmy.ml", NumItems: 2, TimePeriod: "week"})
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, models.Block{
+		Title: "!golang@lemmy.ml",
+		Stories: []models.Story{
+			{
+				Title:        "Go 1.16",
+				Link:         "https://go.dev/blog/go1.16",
+				Hostname:     "go.dev",
+				CommentsLink: ts.URL + "/post/1",
+				NumComments:  3,
+				Score:        40,
+			},
+			{
+				Title:        "Help with generics",
+				Link:         ts.URL + "/post/2",
+				Hostname:     ts.Listener.Addr().String(),
+				CommentsLink: ts.URL + "/post/2",
+				NumComments:  12,
+				Score:        8,
+				Text:         "How do I **start**?",
+			},
+		},
+	}, blocks[0])
+
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeLemmy, Name: "golang", NumItems: 2, TimePeriod: "week"})
+	require.Error(t, err)
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeLemmy, Name: "golang@lemmy.ml", NumItems: 2, TimePeriod: "fortnight"})
+	require.Error(t, err)
+}
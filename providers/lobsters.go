@@ -0,0 +1,153 @@
+package providers
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+)
+
+const (
+	lobstersBaseURL = "https://lobste.rs/"
+	// lobstersMaxPages limits how many pages of a tag are read
+	lobstersMaxPages = 3
+	// lobstersTop is the source name for the site-wide top stories
+	lobstersTop = "top"
+)
+
+// lobstersTopLengths maps time periods to the lengths the top page accepts
+var lobstersTopLengths = map[string]string{
+	"hour":  "1h",
+	"day":   "1d",
+	"week":  "1w",
+	"month": "1m",
+	"year":  "1y",
+	// The top page has no unlimited length, but this predates the site
+	"all": "100y",
+}
+
+// NewLobstersClient creates a new LobstersClient
+func NewLobstersClient(opts ...Option) *LobstersClient {
+	return &LobstersClient{newClientOptions(lobstersBaseURL, opts)}
+}
+
+// LobstersClient interfaces with Lobsters, or another site running its software
+type LobstersClient struct {
+	clientOptions
+}
+
+// LobstersStory is a single story in a listing
+type LobstersStory struct {
+	ShortID      string    `json:"short_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Title        string    `json:"title"`
+	URL          string    `json:"url"`
+	Score        int       `json:"score"`
+	CommentCount int       `json:"comment_count"`
+	Description  string    `json:"description"`
+	CommentsURL  string    `json:"comments_url"`
+	Tags         []string  `json:"tags"`
+}
+
+// FetchStories fetches the top stories posted within period. An empty tag,
+// or "top", fetches stories from the whole site. Stories in a tag are limited
+// to those posted after since instead, which may be zero for no limit
+func (l *LobstersClient) FetchStories(tag, period string, since time.Time) ([]LobstersStory, error) {
+	if tag == "" || tag == lobstersTop {
+		length, ok := lobstersTopLengths[period]
+		if !ok {
+			return nil, fmt.Errorf("unsupported time period %q", period)
+		}
+
+		var stories []LobstersStory
+		topURL := l.baseURL + "top/" + length + ".json"
+		log.Printf("Making request to %q\n", topURL)
+		err := l.getJSON(topURL, nil, &stories)
+		if err != nil {
+			return nil, err
+		}
+		return stories, nil
+	}
+
+	// Tag listings are ordered by hotness, so collect every story in the
+	// period and leave ranking to the caller. Hotness decays with age, so
+	// once a whole page is older than since the rest will be too
+	var stories []LobstersStory
+	for page := 1; page <= lobstersMaxPages; page++ {
+		pageURL := fmt.Sprintf("%st/%s/page/%d.json", l.baseURL, url.PathEscape(tag), page)
+		log.Printf("Making request to %q\n", pageURL)
+
+		var res []LobstersStory
+		err := l.getJSON(pageURL, nil, &res)
+		if err != nil {
+			return nil, err
+		}
+		if len(res) == 0 {
+			break
+		}
+
+		inPeriod := 0
+		for _, story := range res {
+			if story.CreatedAt.After(since) {
+				stories = append(stories, story)
+				inPeriod++
+			}
+		}
+		if inPeriod == 0 {
+			break
+		}
+	}
+
+	log.Printf("Fetched %d stories from Lobsters %q", len(stories), tag)
+	return stories, nil
+}
+
+// Fetch fetches the top stories for a tag as a single Block
+func (l *LobstersClient) Fetch(src models.Source) ([]models.Block, error) {
+	res, err := l.FetchStories(src.Name, src.TimePeriod, src.Cutoff())
+	if err != nil {
+		return nil, err
+	}
+
+	title := src.Title
+	if title == "" {
+		title = "Lobsters"
+		if src.Name != lobstersTop {
+			title += ": " + src.Name
+		}
+	}
+
+	var stories []models.Story
+	for _, story := range res {
+		stories = append(stories, story.ToStory())
+	}
+	return []models.Block{{Title: title, Stories: topStories(stories, src.NumItems)}}, nil
+}
+
+var _ Provider = &LobstersClient{}
+
+// ToStory converts to a story
+func (s LobstersStory) ToStory() models.Story {
+	// Text posts link to their own discussion
+	link := s.URL
+	if link == "" {
+		link = s.CommentsURL
+	}
+	linkURL, err := url.Parse(canonicalURL(link))
+	if err != nil {
+		log.Printf("Failed to parse Link %q: %s", link, err)
+		linkURL = &url.URL{}
+	}
+
+	return models.Story{
+		Title:        s.Title,
+		Link:         linkURL.String(),
+		Hostname:     linkURL.Host,
+		CommentsLink: s.CommentsURL,
+		NumComments:  s.CommentCount,
+		Score:        s.Score,
+		Text:         htmlToMarkdown(s.Description),
+	}
+}
@@ -0,0 +1,81 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLobstersClient_Fetch(t *testing.T) {
+	now := time.Date(2020, 12, 3, 8, 0, 0, 0, time.UTC)
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+
+		switch r.URL.Path {
+		case "/top/1w.json", "/top/100y.json":
+			w.Write([]byte(`[
+				{"short_id": "a", "title": "Rust in the kernel", "url": "https://lwn.net/Articles/1?utm_source=lobsters", "score": 80, "comment_count": 30, "comments_url": "https://lobste.rs/s/a"},
+				{"short_id": "b", "title": "Ask: editors?", "score": 20, "comment_count": 50, "comments_url": "https://lobste.rs/s/b", "description": "<p>Which <em>one</em>?</p>"}
+			]`))
+		case "/t/go/page/1.json":
+			w.Write([]byte(`[
+				{"short_id": "c", "created_at": "2020-12-03T01:00:00Z", "title": "New", "url": "https://go.dev/a", "score": 5},
+				{"short_id": "d", "created_at": "2020-12-01T01:00:00Z", "title": "Old but hot", "url": "https://go.dev/b", "score": 50}
+			]`))
+		case "/t/go/page/2.json":
+			w.Write([]byte(`[
+				{"short_id": "e", "created_at": "2020-12-02T12:00:00Z", "title": "Newer", "url": "https://go.dev/c", "score": 9}
+			]`))
+		case "/t/go/page/3.json":
+			w.Write([]byte(`[
+				{"short_id": "f", "created_at": "2020-11-20T12:00:00Z", "title": "Older", "url": "https://go.dev/d", "score": 90}
+			]`))
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewLobstersClient(WithBaseURL(ts.URL + "/"))
+
+	blocks, err := client.Fetch(models.Source{Type: models.SourceTypeLobsters, Name: "top", NumItems: 5, TimePeriod: "week"})
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "Lobsters", blocks[0].Title)
+	require.Equal(t, []models.Story{
+		{
+			Title:        "Rust in the kernel",
+			Link:         "https://lwn.net/Articles/1",
+			Hostname:     "lwn.net",
+			CommentsLink: "https://lobste.rs/s/a",
+			NumComments:  30,
+			Score:        80,
+		},
+		{
+			Title:        "Ask: editors?",
+			Link:         "https://lobste.rs/s/b",
+			Hostname:     "lobste.rs",
+			CommentsLink: "https://lobste.rs/s/b",
+			NumComments:  50,
+			Score:        20,
+			Text:         "Which one?",
+		},
+	}, blocks[0].Stories)
+
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeLobsters, Name: "top", NumItems: 5, TimePeriod: "all"})
+	require.NoError(t, err)
+
+	// Tag pages are read until a whole page is older than the period
+	requests = nil
+	blocks, err = client.Fetch(models.Source{Type: models.SourceTypeLobsters, Name: "go", NumItems: 5, TimePeriod: "day", Now: now})
+	require.NoError(t, err)
+	require.Equal(t, "Lobsters: go", blocks[0].Title)
+	require.Equal(t, []string{"Newer", "New"}, storyTitles(blocks[0]))
+	require.Equal(t, []string{"/t/go/page/1.json", "/t/go/page/2.json", "/t/go/page/3.json"}, requests)
+}
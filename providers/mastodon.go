@@ -0,0 +1,210 @@
+package providers
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+)
+
+// mastodonMaxPages limits how far back an account's statuses are paged through
+const mastodonMaxPages = 5
+
+// NewMastodonClient creates a new MastodonClient. Servers are taken from each
+// source's name, unless a base URL is given, which is then used for all of them
+func NewMastodonClient(opts ...Option) *MastodonClient {
+	return &MastodonClient{newClientOptions("", opts)}
+}
+
+// MastodonClient fetches public statuses from Mastodon compatible servers
+type MastodonClient struct {
+	clientOptions
+}
+
+// MastodonStatus is a single post
+type MastodonStatus struct {
+	ID              string    `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	URL             string    `json:"url"`
+	Content         string    `json:"content"`
+	SpoilerText     string    `json:"spoiler_text"`
+	Sensitive       bool      `json:"sensitive"`
+	RepliesCount    int       `json:"replies_count"`
+	ReblogsCount    int       `json:"reblogs_count"`
+	FavouritesCount int       `json:"favourites_count"`
+	Account         struct {
+		Acct string `json:"acct"`
+		URL  string `json:"url"`
+	} `json:"account"`
+	Card *struct {
+		URL string `json:"url"`
+	} `json:"card"`
+}
+
+// mastodonSource is a parsed source name, either "@user@host" or "#tag@host"
+type mastodonSource struct {
+	host    string
+	account string
+	tag     string
+}
+
+func parseMastodonSource(name string) (mastodonSource, error) {
+	ms := mastodonSource{}
+	var local string
+	switch {
+	case strings.HasPrefix(name, "@"):
+		local, ms.host = splitAt(strings.TrimPrefix(name, "@"))
+		ms.account = local
+	case strings.HasPrefix(name, "#"):
+		local, ms.host = splitAt(strings.TrimPrefix(name, "#"))
+		ms.tag = local
+	}
+	if local == "" || ms.host == "" {
+		return ms, fmt.Errorf("invalid source %q, expected @user@host or #tag@host", name)
+	}
+
+	return ms, nil
+}
+
+// splitAt splits "a@b" into "a" and "b"
+func splitAt(s string) (string, string) {
+	i := strings.LastIndex(s, "@")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+func (m *MastodonClient) apiURL(host, path string) string {
+	if m.baseURL != "" {
+		return strings.TrimSuffix(m.baseURL, "/") + path
+	}
+	return "https://" + host + path
+}
+
+// FetchStatuses fetches public statuses for an account or hashtag posted after since
+func (m *MastodonClient) FetchStatuses(name string, since time.Time) ([]MastodonStatus, error) {
+	ms, err := parseMastodonSource(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoint string
+	if ms.account != "" {
+		account := struct {
+			ID string `json:"id"`
+		}{}
+		lookupURL := m.apiURL(ms.host, "/api/v1/accounts/lookup?acct="+url.QueryEscape(ms.account))
+		err = m.getJSON(lookupURL, nil, &account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up account: %w", err)
+		}
+		endpoint = m.apiURL(ms.host, "/api/v1/accounts/"+url.PathEscape(account.ID)+
+			"/statuses?exclude_replies=true&exclude_reblogs=true&limit=40")
+	} else {
+		endpoint = m.apiURL(ms.host, "/api/v1/timelines/tag/"+url.PathEscape(ms.tag)+"?limit=40")
+	}
+
+	// Statuses are returned newest first, so page back until we pass since
+	var statuses []MastodonStatus
+	maxID := ""
+	for page := 0; page < mastodonMaxPages; page++ {
+		pageURL := endpoint
+		if maxID != "" {
+			pageURL += "&max_id=" + url.QueryEscape(maxID)
+		}
+
+		log.Printf("Making request to %q\n", pageURL)
+		var res []MastodonStatus
+		err = m.getJSON(pageURL, nil, &res)
+		if err != nil {
+			return nil, err
+		}
+
+		done := len(res) == 0
+		for _, status := range res {
+			if !status.CreatedAt.After(since) {
+				done = true
+				continue
+			}
+			statuses = append(statuses, status)
+		}
+		if done {
+			break
+		}
+		maxID = res[len(res)-1].ID
+	}
+
+	log.Printf("Fetched %d statuses from %q", len(statuses), name)
+	return statuses, nil
+}
+
+// Fetch fetches the most boosted and favourited statuses of each account or
+// hashtag in the source, as one block per name or a single merged block
+func (m *MastodonClient) Fetch(src models.Source) ([]models.Block, error) {
+	var blocks []models.Block
+	var merged []models.Story
+	for _, name := range src.AllNames() {
+		statuses, err := m.FetchStatuses(name, src.Cutoff())
+		if err != nil {
+			return nil, fmt.Errorf("fetch statuses %q: %w", name, err)
+		}
+
+		var stories []models.Story
+		for _, status := range statuses {
+			stories = append(stories, status.ToStory())
+		}
+
+		if src.Merge {
+			merged = append(merged, stories...)
+			continue
+		}
+
+		title := src.Title
+		if title == "" {
+			title = name
+		}
+		blocks = append(blocks, models.Block{Title: title, Stories: topStories(stories, src.NumItems)})
+	}
+
+	if src.Merge {
+		title := src.Title
+		if title == "" {
+			title = "Mastodon"
+		}
+		blocks = append(blocks, models.Block{Title: title, Stories: topStories(merged, src.NumItems)})
+	}
+
+	return blocks, nil
+}
+
+var _ Provider = &MastodonClient{}
+
+// ToStory converts to a story
+func (s MastodonStatus) ToStory() models.Story {
+	text := htmlToMarkdown(s.Content)
+	// Content behind a content warning is left out, only the warning is shown
+	if s.SpoilerText != "" {
+		text = "CW: " + htmlToMarkdown(s.SpoilerText)
+	}
+
+	story := models.Story{
+		Author:       "@" + s.Account.Acct,
+		CommentsLink: s.URL,
+		NumComments:  s.RepliesCount,
+		Score:        s.ReblogsCount + s.FavouritesCount,
+		Text:         text,
+	}
+	if s.Card != nil {
+		linkURL, err := url.Parse(canonicalURL(s.Card.URL))
+		if err == nil {
+			story.Link = linkURL.String()
+			story.Hostname = linkURL.Host
+		}
+	}
+
+	return story
+}
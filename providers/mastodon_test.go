@@ -0,0 +1,116 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMastodonClient_Fetch(t *testing.T) {
+	now := time.Date(2020, 12, 3, 8, 0, 0, 0, time.UTC)
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+
+		switch r.URL.Path {
+		case "/api/v1/accounts/lookup":
+			require.Equal(t, "gopher", r.URL.Query().Get("acct"))
+			w.Write([]byte(`{"id": "42"}`))
+		case "/api/v1/accounts/42/statuses":
+			require.Equal(t, "true", r.URL.Query().Get("exclude_replies"))
+			require.Equal(t, "true", r.URL.Query().Get("exclude_reblogs"))
+
+			// Statuses are paged newest first, the second page crosses since
+			switch r.URL.Query().Get("max_id") {
+			case "":
+				w.Write([]byte(`[
+					{"id": "3", "created_at": "2020-12-03T07:00:00Z", "url": "https://example.social/@gopher/3", "content": "<p>Third <a href=\"https://example.social/tags/go\">#go</a> [x](javascript:alert(1))</p>", "favourites_count": 1, "account": {"acct": "gopher"}},
+					{"id": "2", "created_at": "2020-12-02T20:00:00Z", "url": "https://example.social/@gopher/2", "content": "<p>Second</p>", "reblogs_count": 4, "favourites_count": 6, "replies_count": 2, "account": {"acct": "gopher"},
+					 "card": {"url": "https://go.dev/blog?utm_source=mastodon"}}
+				]`))
+			case "2":
+				w.Write([]byte(`[
+					{"id": "1", "created_at": "2020-12-02T10:00:00Z", "url": "https://example.social/@gopher/1", "content": "<p>First</p>", "account": {"acct": "gopher"}},
+					{"id": "0", "created_at": "2020-12-01T10:00:00Z", "url": "https://example.social/@gopher/0", "content": "<p>Too old</p>", "favourites_count": 100, "account": {"acct": "gopher"}}
+				]`))
+			default:
+				t.Errorf("unexpected page %q", r.URL.Query().Get("max_id"))
+			}
+		case "/api/v1/timelines/tag/golang":
+			if r.URL.Query().Get("max_id") != "" {
+				w.Write([]byte(`[]`))
+				return
+			}
+			w.Write([]byte(`[
+				{"id": "9", "created_at": "2020-12-03T06:00:00Z", "url": "https://example.social/@rob/9", "content": "<p>Hidden</p>", "spoiler_text": "Spoilers", "account": {"acct": "rob@other.social"}}
+			]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewMastodonClient(WithBaseURL(ts.URL))
+
+	src := models.Source{Type: models.SourceTypeMastodon, Name: "@gopher@example.social", NumItems: 2, TimePeriod: "day", Now: now}
+	blocks, err := client.Fetch(src)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "@gopher@example.social", blocks[0].Title)
+	require.Equal(t, []models.Story{
+		{
+			Author:       "@gopher",
+			Link:         "https://go.dev/blog",
+			Hostname:     "go.dev",
+			CommentsLink: "https://example.social/@gopher/2",
+			NumComments:  2,
+			Score:        10,
+			Text:         "Second",
+		},
+		{
+			Author:       "@gopher",
+			CommentsLink: "https://example.social/@gopher/3",
+			Score:        1,
+			// Markdown in the content is escaped, only links from anchors are kept
+			Text: `Third [\#go](https://example.social/tags/go) \[x\]\(javascript:alert\(1\)\)`,
+		},
+	}, blocks[0].Stories)
+
+	// Paging stops once statuses are older than since
+	statuses, err := client.FetchStatuses("@gopher@example.social", now.AddDate(0, 0, -1))
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	require.Equal(t, "1", statuses[2].ID)
+
+	requests = nil
+	blocks, err = client.Fetch(models.Source{Type: models.SourceTypeMastodon, Name: "#golang@example.social", NumItems: 2, TimePeriod: "day", Now: now})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/api/v1/timelines/tag/golang?limit=40",
+		"/api/v1/timelines/tag/golang?limit=40&max_id=9",
+	}, requests)
+	require.Equal(t, "@rob@other.social", blocks[0].Stories[0].Author)
+	require.Equal(t, "CW: Spoilers", blocks[0].Stories[0].Text)
+
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeMastodon, Name: "gopher", NumItems: 2, TimePeriod: "day"})
+	require.Error(t, err)
+}
+
+func TestMastodonClient_FetchMaxPages(t *testing.T) {
+	pages := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		w.Write([]byte(`[{"id": "1", "created_at": "2020-12-03T07:00:00Z", "account": {"acct": "busy"}}]`))
+	}))
+	defer ts.Close()
+
+	client := NewMastodonClient(WithBaseURL(ts.URL))
+	statuses, err := client.FetchStatuses("#busy@example.social", time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, statuses, mastodonMaxPages)
+	require.Equal(t, mastodonMaxPages, pages)
+}
@@ -0,0 +1,79 @@
+package providers
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/hebo/mailshine/models"
+)
+
+// NewPodcastClient creates a new PodcastClient
+func NewPodcastClient(opts ...Option) *PodcastClient {
+	return &PodcastClient{*NewFeedClient(opts...)}
+}
+
+// PodcastClient reads podcast feeds
+type PodcastClient struct {
+	FeedClient
+}
+
+// Fetch fetches episodes published since the feed's previous digest, or
+// within the time period for a feed's first digest. Each show becomes its own
+// block, unless the source is merged
+func (p *PodcastClient) Fetch(src models.Source) ([]models.Block, error) {
+	since := src.Since
+	if since.IsZero() {
+		since = src.Cutoff()
+	}
+
+	var blocks []models.Block
+	merged := models.Block{Title: src.Title}
+	if merged.Title == "" {
+		merged.Title = "Podcasts"
+	}
+	for _, feedURL := range src.AllNames() {
+		feed, err := p.FetchFeed(feedURL)
+		if err != nil {
+			return nil, fmt.Errorf("fetch podcast %q: %w", feedURL, err)
+		}
+
+		var stories []models.Story
+		for _, entry := range feed.Since(since) {
+			story := entry.ToStory()
+			story.ID = entry.StoryID(feedURL)
+			if story.Link == "" {
+				// Episodes without a web page link to their audio
+				enclosureURL, err := url.Parse(entry.Enclosure)
+				if err == nil {
+					story.Link = enclosureURL.String()
+					story.Hostname = enclosureURL.Host
+				}
+			}
+			if src.Merge {
+				story.Title = feed.Title + ": " + story.Title
+			}
+			stories = append(stories, story)
+		}
+		if len(stories) > src.NumItems {
+			stories = stories[:src.NumItems]
+		}
+
+		if src.Merge {
+			merged.Stories = append(merged.Stories, stories...)
+			continue
+		}
+
+		title := src.Title
+		if title == "" {
+			title = feed.Title
+		}
+		blocks = append(blocks, models.Block{Title: title, Stories: stories})
+	}
+
+	if src.Merge {
+		blocks = append(blocks, merged)
+	}
+	return blocks, nil
+}
+
+var _ Provider = &PodcastClient{}
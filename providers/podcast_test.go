@@ -0,0 +1,88 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+const podcastFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+<channel>
+<title>Go Time</title>
+<item>
+	<title>Generics, finally</title>
+	<guid isPermaLink="false">ep-2</guid>
+	<link>https://changelog.com/gotime/2</link>
+	<pubDate>Wed, 02 Dec 2020 20:00:00 +0000</pubDate>
+	<enclosure url="https://cdn.changelog.com/gotime-2.mp3" length="1000" type="audio/mpeg"/>
+	<itunes:duration>1:02:03</itunes:duration>
+	<itunes:summary>All about &lt;T any&gt;</itunes:summary>
+	<itunes:image href="https://cdn.changelog.com/gotime-2.png"/>
+</item>
+<item>
+	<title>Bonus</title>
+	<guid isPermaLink="false">ep-1b</guid>
+	<pubDate>Wed, 02 Dec 2020 10:00:00 +0000</pubDate>
+	<enclosure url="https://media.example.com/bonus.mp3" length="1000" type="audio/mpeg"/>
+	<itunes:duration>1805</itunes:duration>
+</item>
+<item>
+	<title>Old news</title>
+	<guid isPermaLink="false">ep-1</guid>
+	<link>https://changelog.com/gotime/1</link>
+	<pubDate>Mon, 23 Nov 2020 20:00:00 +0000</pubDate>
+	<enclosure url="https://cdn.changelog.com/gotime-1.mp3" length="1000" type="audio/mpeg"/>
+</item>
+</channel>
+</rss>`
+
+func TestPodcastClient_Fetch(t *testing.T) {
+	now := time.Date(2020, 12, 3, 8, 0, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(podcastFeed))
+	}))
+	defer ts.Close()
+
+	client := NewPodcastClient()
+	src := models.Source{Type: models.SourceTypePodcast, Name: ts.URL + "/gotime", NumItems: 5, TimePeriod: "week", Now: now}
+	blocks, err := client.Fetch(src)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "Go Time", blocks[0].Title)
+	require.Equal(t, []models.Story{
+		{
+			ID:        ts.URL + "/gotime#ep-2",
+			Title:     "Generics, finally",
+			Link:      "https://changelog.com/gotime/2",
+			Hostname:  "changelog.com",
+			Text:      "All about &lt;T any&gt;",
+			Image:     "https://cdn.changelog.com/gotime-2.png",
+			Duration:  time.Hour + 2*time.Minute + 3*time.Second,
+			Enclosure: "https://cdn.changelog.com/gotime-2.mp3",
+		},
+		{
+			ID:        ts.URL + "/gotime#ep-1b",
+			Title:     "Bonus",
+			Link:      "https://media.example.com/bonus.mp3",
+			Hostname:  "media.example.com",
+			Duration:  30*time.Minute + 5*time.Second,
+			Enclosure: "https://media.example.com/bonus.mp3",
+		},
+	}, blocks[0].Stories)
+
+	// Episodes since the previous digest, titled with their show when merged
+	src.Since = time.Date(2020, 12, 2, 12, 0, 0, 0, time.UTC)
+	src.Names = []string{ts.URL + "/gotime", ts.URL + "/gotime-mirror"}
+	src.Merge = true
+	blocks, err = client.Fetch(src)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "Podcasts", blocks[0].Title)
+	require.Equal(t, []string{"Go Time: Generics, finally", "Go Time: Generics, finally"}, storyTitles(blocks[0]))
+}
@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hebo/mailshine/models"
+)
+
+// Provider fetches content from a source and converts it into Blocks
+type Provider interface {
+	Fetch(src models.Source) ([]models.Block, error)
+}
+
+// ContextProvider is a Provider which can stop fetching when a context is done
+type ContextProvider interface {
+	Provider
+	FetchContext(ctx context.Context, src models.Source) ([]models.Block, error)
+}
+
+// DeliveryMarker is a Provider which keeps track of which stories have been
+// delivered in a digest, e.g. by marking emails read
+type DeliveryMarker interface {
+	Provider
+	MarkDelivered(src models.Source, stories []models.Story) error
+}
+
+// Registry maps source types to the Provider that handles them
+type Registry struct {
+	providers map[string]Provider
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]Provider)}
+}
+
+// Register sets the Provider used for sources of the given type
+func (r *Registry) Register(sourceType string, p Provider) {
+	r.providers[sourceType] = p
+}
+
+// Fetch fetches a source using the Provider registered for its type
+func (r *Registry) Fetch(src models.Source) ([]models.Block, error) {
+	return r.FetchContext(context.Background(), src)
+}
+
+// FetchContext is Fetch, stopping early if ctx is done. Providers which
+// aren't a ContextProvider can't be stopped once they've started
+func (r *Registry) FetchContext(ctx context.Context, src models.Source) ([]models.Block, error) {
+	p, ok := r.providers[src.Type]
+	if !ok {
+		return nil, fmt.Errorf("no provider registered for source type %q", src.Type)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var blocks []models.Block
+	var err error
+	if cp, ok := p.(ContextProvider); ok {
+		blocks, err = cp.FetchContext(ctx, src)
+	} else {
+		blocks, err = p.Fetch(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range blocks {
+		if blocks[i].Type == "" {
+			blocks[i].Type = src.Type
+		}
+	}
+
+	return blocks, nil
+}
+
+// MarkDelivered tells the provider of a source which of its stories are in
+// a stored digest, if it keeps track of them
+func (r *Registry) MarkDelivered(src models.Source, stories []models.Story) error {
+	if dm, ok := r.providers[src.Type].(DeliveryMarker); ok {
+		return dm.MarkDelivered(src, stories)
+	}
+	return nil
+}
+
+// topStories returns up to n stories with the highest scores, in order
+func topStories(stories []models.Story, n int) []models.Story {
+	sort.SliceStable(stories, func(i, j int) bool {
+		return stories[i].Score > stories[j].Score
+	})
+	if len(stories) > n {
+		stories = stories[:n]
+	}
+	return stories
+}
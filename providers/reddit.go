@@ -1,23 +1,104 @@
 package providers
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hebo/mailshine/models"
 )
 
-// NewRedditClient creates a new RedditClient
-func NewRedditClient(clientID, clientSecret string) (*RedditClient, error) {
-	client := &RedditClient{clientID: clientID, clientSecret: clientSecret}
+const (
+	redditAPIURL   = "https://oauth.reddit.com/"
+	redditTokenURL = "https://www.reddit.com/api/v1/access_token"
+	redditBaseURL  = "https://old.reddit.com"
+
+	// redditMaxRetries is how many times failed requests are retried
+	redditMaxRetries = 4
+	// redditMaxWait is the longest the client waits for a rate limit to reset,
+	// before giving up with ErrRedditRateLimited
+	redditMaxWait = 5 * time.Minute
+)
+
+var (
+	// ErrRedditAuth means Reddit rejected the client ID and secret
+	ErrRedditAuth = errors.New("reddit authentication failed")
+	// ErrSubredditUnavailable means a subreddit is banned, private,
+	// quarantined or doesn't exist
+	ErrSubredditUnavailable = errors.New("subreddit unavailable")
+	// ErrRedditRateLimited means Reddit's rate limit was exceeded, and won't
+	// reset soon enough to wait for
+	ErrRedditRateLimited = errors.New("reddit rate limit exceeded")
+)
+
+// RedditError is an unsuccessful response from Reddit. It matches
+// ErrRedditAuth, ErrSubredditUnavailable or ErrRedditRateLimited with errors.Is
+type RedditError struct {
+	URL        string
+	StatusCode int
+	// Reason is Reddit's explanation, e.g. "private" or "banned"
+	Reason string
+	// RetryAfter is how long Reddit asked to wait before retrying
+	RetryAfter time.Duration
+}
+
+func (e *RedditError) Error() string {
+	msg := fmt.Sprintf("unexpected status %d from %s", e.StatusCode, e.URL)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// Is reports whether the error is one of the Err values for Reddit
+func (e *RedditError) Is(target error) bool {
+	switch target {
+	case ErrRedditAuth:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrSubredditUnavailable:
+		// Listings of subreddits which don't exist redirect to a search
+		return e.StatusCode == http.StatusForbidden || e.StatusCode == http.StatusNotFound ||
+			(e.StatusCode >= 300 && e.StatusCode < 400)
+	case ErrRedditRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// retryable reports whether the request might succeed if it's sent again
+func (e *RedditError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// NewRedditClient creates a new RedditClient. WithBaseURL sets the API URL,
+// WithTokenURL the OAuth token URL, and WithWebURL the site stories link to
+func NewRedditClient(clientID, clientSecret string, opts ...Option) (*RedditClient, error) {
+	o := newClientOptions(redditAPIURL, append([]Option{
+		WithTokenURL(redditTokenURL), WithWebURL(redditBaseURL)}, opts...))
+
+	// Listings of subreddits which don't exist redirect to a search, which
+	// should be an error rather than followed
+	httpClient := *o.httpClient
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	o.httpClient = &httpClient
+
+	client := &RedditClient{
+		clientOptions: o,
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		backoff:       time.Second,
+	}
 	if clientID == "" || clientSecret == "" {
 		return client, errors.New("missing client ID or client secret")
 	}
@@ -25,12 +106,20 @@ func NewRedditClient(clientID, clientSecret string) (*RedditClient, error) {
 	return client, nil
 }
 
-// RedditClient interfaces with reddit
+// RedditClient interfaces with reddit. It's safe for concurrent use
 type RedditClient struct {
+	clientOptions
 	clientID     string
 	clientSecret string
-	mu           sync.Mutex // guards t
-	t            accessToken
+	// backoff is the wait before the first retry, which doubles each time
+	backoff time.Duration
+
+	mu sync.Mutex // guards t
+	t  accessToken
+
+	rateMu sync.Mutex // guards pausedUntil
+	// pausedUntil is when the rate limit resets, once it's used up
+	pausedUntil time.Time
 }
 
 type accessToken struct {
@@ -46,7 +135,7 @@ type accessTokenResponse struct {
 const tokenGracePeriod = 30 * time.Second
 
 // token returns an access token, and fetches a new one if necessary
-func (r *RedditClient) token() (accessToken, error) {
+func (r *RedditClient) token(ctx context.Context) (accessToken, error) {
 	needsToken := false
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -59,7 +148,7 @@ func (r *RedditClient) token() (accessToken, error) {
 	}
 
 	if needsToken {
-		token, err := r.fetchAccessToken()
+		token, err := r.fetchAccessToken(ctx)
 		if err != nil {
 			return r.t, err
 		}
@@ -70,28 +159,35 @@ func (r *RedditClient) token() (accessToken, error) {
 	return r.t, nil
 }
 
+// resetToken discards the access token, so the next request fetches a new one
+func (r *RedditClient) resetToken() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.t = accessToken{}
+}
+
 // fetchAccessToken fetches an accessToken
-func (r *RedditClient) fetchAccessToken() (accessToken, error) {
+func (r *RedditClient) fetchAccessToken(ctx context.Context) (accessToken, error) {
 	form := url.Values{}
 	form.Add("grant_type", "client_credentials")
 	form.Add("device_id", "3v4b553")
 
-	req, _ := http.NewRequest("POST", "https://www.reddit.com/api/v1/access_token", bytes.NewBufferString(form.Encode()))
-	req.SetBasicAuth(r.clientID, r.clientSecret)
-	req.Header.Add("User-agent", "mailshine/v0.1")
-
-	cli := &http.Client{}
-	resp, err := cli.Do(req)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer resp.Body.Close()
-
 	res := accessTokenResponse{}
 	tok := accessToken{}
-	err = json.NewDecoder(resp.Body).Decode(&res)
+	err := r.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", r.tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(r.clientID, r.clientSecret)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}, &res)
 	if err != nil {
-		return tok, err
+		return tok, fmt.Errorf("failed to get token: %w", err)
+	}
+	if res.AccessToken == "" {
+		return tok, ErrRedditAuth
 	}
 
 	tok.Token = res.AccessToken
@@ -99,87 +195,584 @@ func (r *RedditClient) fetchAccessToken() (accessToken, error) {
 	return tok, nil
 }
 
-// FetchSubreddit fetches info for a subreddit
-func (r *RedditClient) FetchSubreddit(subredditName, period string, numStories int) (RedditListingResponse, error) {
-	listingRes := RedditListingResponse{}
-	token, err := r.token()
+const (
+	defaultRedditSort = "top"
+	// redditMaxLimit is the most stories Reddit returns in one listing
+	redditMaxLimit = 100
+	// redditMaxPages is how many pages of a listing are fetched to fill a block
+	redditMaxPages = 5
+	// redditCommentConcurrency is how many posts' comments are fetched at once
+	redditCommentConcurrency = 4
+	// redditImageWidth is the minimum width of preview images, to fit the digest
+	redditImageWidth = 640
+)
+
+// FetchSubreddit fetches info for a subreddit. The period is only used for
+// sorts which support it, such as top
+func (r *RedditClient) FetchSubreddit(ctx context.Context, subredditName, sort, period string, numStories int) (RedditListingResponse, error) {
+	return r.FetchListing(ctx, subredditName, "", sort, period, numStories)
+}
+
+// FetchListing fetches the posts of a subreddit, multireddit or user, or
+// searches them if query is set
+func (r *RedditClient) FetchListing(ctx context.Context, name, query, sort, period string, numStories int) (RedditListingResponse, error) {
+	pager, err := r.ListingPager(ctx, name, query, sort, period, numStories, 1)
 	if err != nil {
-		return listingRes, fmt.Errorf("failed to get token: %w", err)
+		return RedditListingResponse{}, err
 	}
 
-	baseURL, err := url.Parse("https://oauth.reddit.com/")
+	pager.Next()
+	return pager.Page(), pager.Err()
+}
+
+// ListingPager returns a RedditPager for the listing FetchListing fetches,
+// which follows it for up to maxPages pages of pageSize posts
+func (r *RedditClient) ListingPager(ctx context.Context, name, query, sort, period string, pageSize, maxPages int) (*RedditPager, error) {
+	path, params, err := redditListingPath(name, query, sort, period)
 	if err != nil {
-		fmt.Println("Malformed URL: ", err.Error())
-		return listingRes, err
+		return nil, err
+	}
+	params.Add("limit", strconv.Itoa(pageSize))
+
+	return &RedditPager{ctx: ctx, client: r, name: name, path: path, params: params, maxPages: maxPages}, nil
+}
+
+// RedditPager pages through a listing, so callers can stop once they have
+// enough posts. Like a bufio.Scanner, call Next to fetch each page, Page to get
+// it, and Err once Next returns false
+type RedditPager struct {
+	ctx      context.Context
+	client   *RedditClient
+	name     string
+	path     string
+	params   url.Values
+	maxPages int
+
+	pages int
+	seen  int
+	done  bool
+	page  RedditListingResponse
+	err   error
+}
+
+// Next fetches the next page. It returns false when the listing has no more
+// pages, the page budget is spent, or there was an error
+func (p *RedditPager) Next() bool {
+	if p.done || p.pages >= p.maxPages {
+		return false
 	}
 
-	baseURL.Path += fmt.Sprintf("r/%s/top", subredditName)
+	page := RedditListingResponse{}
+	p.err = p.client.apiGet(p.ctx, p.path, p.params, &page)
+	if p.err != nil {
+		p.done = true
+		return false
+	}
+	p.pages++
+	p.page = page
+
+	// fmt.Printf("List Response-> %+v\n", page)
+	log.Printf("Fetched %d stories from %q", len(page.Data.Children), p.name)
+
+	// Reddit expects the number of posts already seen with the cursor
+	p.seen += len(page.Data.Children)
+	p.params.Set("after", page.Data.After)
+	p.params.Set("count", strconv.Itoa(p.seen))
+	if page.Data.After == "" {
+		p.done = true
+	}
+	return true
+}
 
-	// Prepare Query Parameters
+// Page returns the page fetched by the last call to Next
+func (p *RedditPager) Page() RedditListingResponse {
+	return p.page
+}
+
+// Err returns the error that stopped Next, if any
+func (p *RedditPager) Err() error {
+	return p.err
+}
+
+// redditListingPath returns the API path and parameters for a source name
+func redditListingPath(name, query, sort, period string) (string, url.Values, error) {
 	params := url.Values{}
-	params.Add("t", period)
-	params.Add("limit", strconv.Itoa(numStories))
-	params.Add("raw_json", "1")
+	if query != "" {
+		if sort == "" {
+			sort = "relevance"
+		}
+		params.Add("q", query)
+		params.Add("sort", sort)
+		params.Add("t", period)
+		if name == models.RedditFrontPage {
+			return "search", params, nil
+		}
+		params.Add("restrict_sr", "1")
+	}
+
+	if sort == "" {
+		sort = defaultRedditSort
+	}
+	if query == "" && models.RedditSortUsesPeriod(sort) {
+		params.Add("t", period)
+	}
 
-	baseURL.RawQuery = params.Encode()
+	var base string
+	parts := strings.Split(name, "/")
+	switch {
+	case models.IsRedditUser(name):
+		if query != "" {
+			return "", nil, fmt.Errorf("can't search user %q", name)
+		}
+		params.Add("sort", sort)
+		return fmt.Sprintf("user/%s/submitted", parts[1]), params, nil
+	case len(parts) == 4 && (parts[0] == "u" || parts[0] == "user") && parts[2] == "m":
+		base = fmt.Sprintf("user/%s/m/%s", parts[1], parts[3])
+	case len(parts) == 1 && name != "":
+		base = "r/" + name
+		if name == models.RedditFrontPage {
+			return sort, params, nil
+		}
+	default:
+		return "", nil, fmt.Errorf("invalid reddit source %q", name)
+	}
 
-	req, _ := http.NewRequest("GET", baseURL.String(), nil)
-	req.Header.Add("User-agent", "mailshine/v0.1")
-	req.Header.Add("Authorization", fmt.Sprintf("bearer %s", token.Token))
+	if query != "" {
+		return base + "/search", params, nil
+	}
+	return base + "/" + sort, params, nil
+}
 
-	log.Printf("Making request to %q\n", baseURL.String())
-	cli := &http.Client{}
-	resp, err := cli.Do(req)
-	if err != nil {
-		log.Fatal(err)
+// redditTitle is the default block title for a source
+func redditTitle(src models.Source) string {
+	title := "r/" + src.Name
+	parts := strings.Split(src.Name, "/")
+	switch {
+	case src.Name == models.RedditFrontPage:
+		title = "Reddit"
+	case models.IsRedditUser(src.Name):
+		title = "u/" + parts[1]
+	case len(parts) == 4:
+		title = "m/" + parts[3]
 	}
-	defer resp.Body.Close()
 
-	err = json.NewDecoder(resp.Body).Decode(&listingRes)
+	if src.Query != "" {
+		title += fmt.Sprintf(": %q", src.Query)
+	}
+	return title
+}
+
+// FetchComments fetches the top level comments of a post with the highest scores
+func (r *RedditClient) FetchComments(ctx context.Context, subredditName, postID string, numComments int) ([]models.Comment, error) {
+	params := url.Values{}
+	params.Add("sort", "top")
+	params.Add("depth", "1")
+	// Leave room for stickied comments, which are skipped
+	params.Add("limit", strconv.Itoa(numComments+1))
+
+	// The response is a listing with the post, then a listing of its comments
+	var listings []RedditCommentListing
+	err := r.apiGet(ctx, fmt.Sprintf("r/%s/comments/%s", subredditName, postID), params, &listings)
 	if err != nil {
-		return listingRes, err
+		return nil, err
+	}
+	if len(listings) < 2 {
+		return nil, fmt.Errorf("unexpected comments response with %d listings", len(listings))
+	}
+
+	var comments []models.Comment
+	for _, child := range listings[1].Data.Children {
+		c := child.Data
+		// "more" placeholders have no body, and stickied comments are from mods
+		if child.Kind != "t1" || c.Stickied || len(comments) == numComments {
+			continue
+		}
+		// Bodies are fetched with raw_json=1, so drop any HTML in them
+		comments = append(comments, models.Comment{
+			Author: "u/" + c.Author,
+			Text:   sanitizeMarkdown(c.Body),
+			Score:  c.Score,
+			Link:   strings.TrimSuffix(r.webURL, "/") + c.Permalink,
+		})
+	}
+
+	return comments, nil
+}
+
+// apiGet requests a path of the OAuth API and decodes the JSON response into v
+func (r *RedditClient) apiGet(ctx context.Context, path string, params url.Values, v interface{}) error {
+	params.Set("raw_json", "1")
+	apiURL := strings.TrimSuffix(r.baseURL, "/") + "/" + path + "?" + params.Encode()
+
+	get := func(ctx context.Context) (*http.Request, error) {
+		token, err := r.token(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token.Token))
+		return req, nil
+	}
+
+	log.Printf("Making request to %q\n", apiURL)
+	err := r.do(ctx, get, v)
+	var redditErr *RedditError
+	if errors.As(err, &redditErr) && redditErr.StatusCode == http.StatusUnauthorized {
+		// The token may have been revoked early, so try once more with a new one
+		r.resetToken()
+		err = r.do(ctx, get, v)
+	}
+	return err
+}
+
+// do sends a request, retrying transport errors, server errors and rate
+// limits with exponential backoff, and decodes a successful JSON response into
+// v. newReq is called for each attempt
+func (r *RedditClient) do(ctx context.Context, newReq func(context.Context) (*http.Request, error), v interface{}) error {
+	var err error
+	wait := r.backoff
+	for attempt := 0; attempt <= redditMaxRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying Reddit request in %s: %s", wait, err)
+			if err := sleepContext(ctx, wait); err != nil {
+				return err
+			}
+			wait *= 2
+		}
+
+		err = r.waitForRateLimit(ctx)
+		if err != nil {
+			return err
+		}
+		var req *http.Request
+		req, err = newReq(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-agent", userAgent)
+
+		var resp *http.Response
+		resp, err = r.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Replaying the same request won't find it the next time either
+			if errors.Is(err, ErrCassetteMiss) {
+				return err
+			}
+			continue
+		}
+		r.updateRateLimit(resp.Header)
+
+		if resp.StatusCode == http.StatusOK {
+			defer resp.Body.Close()
+			return decodeJSON(resp.Body, v)
+		}
+
+		redditErr := newRedditError(resp)
+		resp.Body.Close()
+		err = redditErr
+		if !redditErr.retryable() {
+			return err
+		}
+		if redditErr.RetryAfter > redditMaxWait {
+			return fmt.Errorf("%w: resets in %s", ErrRedditRateLimited, redditErr.RetryAfter)
+		}
+		if redditErr.RetryAfter > wait {
+			wait = redditErr.RetryAfter
+		}
 	}
 
-	// fmt.Printf("List Response-> %+v\n", listingRes)
-	log.Printf("Fetched %d stories from %q", len(listingRes.Data.Children), subredditName)
-	return listingRes, nil
+	return err
 }
 
-const redditBaseURL = "https://old.reddit.com"
+// newRedditError reads the details of an unsuccessful response
+func newRedditError(resp *http.Response) *RedditError {
+	e := &RedditError{URL: resp.Request.URL.String(), StatusCode: resp.StatusCode}
+
+	body := struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}{}
+	// Errors are small, so don't read much of bodies which aren't
+	err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body)
+	if err == nil {
+		e.Reason = body.Reason
+		if e.Reason == "" {
+			e.Reason = body.Message
+		}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		e.RetryAfter = headerSeconds(resp.Header, "Retry-After")
+		if e.RetryAfter == 0 {
+			e.RetryAfter = headerSeconds(resp.Header, "X-Ratelimit-Reset")
+		}
+	}
+	return e
+}
+
+// updateRateLimit pauses requests until the rate limit resets, once Reddit's
+// X-Ratelimit headers say it's used up
+func (r *RedditClient) updateRateLimit(h http.Header) {
+	remaining, err := strconv.ParseFloat(h.Get("X-Ratelimit-Remaining"), 64)
+	if err != nil || remaining >= 1 {
+		return
+	}
+
+	r.rateMu.Lock()
+	defer r.rateMu.Unlock()
+	r.pausedUntil = time.Now().Add(headerSeconds(h, "X-Ratelimit-Reset"))
+}
+
+// waitForRateLimit waits until requests are allowed by the rate limit
+func (r *RedditClient) waitForRateLimit(ctx context.Context) error {
+	r.rateMu.Lock()
+	wait := time.Until(r.pausedUntil)
+	r.rateMu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	if wait > redditMaxWait {
+		return fmt.Errorf("%w: resets in %s", ErrRedditRateLimited, wait.Round(time.Second))
+	}
+	log.Printf("Reddit rate limit reached, waiting %s", wait.Round(time.Second))
+	return sleepContext(ctx, wait)
+}
+
+// headerSeconds parses a header which is a number of seconds
+func headerSeconds(h http.Header, key string) time.Duration {
+	seconds, err := strconv.ParseFloat(h.Get(key), 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// sleepContext waits for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Fetch fetches the stories of a subreddit, multireddit, user or search as a single Block
+func (r *RedditClient) Fetch(src models.Source) ([]models.Block, error) {
+	return r.FetchContext(context.Background(), src)
+}
+
+// FetchContext is Fetch, stopping early if ctx is done
+func (r *RedditClient) FetchContext(ctx context.Context, src models.Source) ([]models.Block, error) {
+	pageSize := src.NumItems
+	if src.Window > 0 || !src.Filter.IsEmpty() || pageSize > redditMaxLimit {
+		// Some stories will be older than the window or filtered out, so
+		// fetch full pages to still fill the block
+		pageSize = redditMaxLimit
+	}
+
+	filter, err := src.Filter.Matcher()
+	if err != nil {
+		return nil, err
+	}
 
-// ToBlock converts to a block
-func (l RedditListingResponse) ToBlock(title string) models.Block {
-	commentsURL, err := url.Parse(redditBaseURL)
+	pager, err := r.ListingPager(ctx, src.Name, src.Query, src.Sort, src.TimePeriod, pageSize, redditMaxPages)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("fetch reddit listing %q: %w", src.Name, err)
 	}
+	var since time.Time
+	if src.Window > 0 {
+		since = src.Cutoff()
+	}
+
+	listing := RedditListingResponse{}
+	for len(listing.Data.Children) < src.NumItems && pager.Next() {
+		page := pager.Page()
+		page.keep(filter, since, src.NumItems-len(listing.Data.Children))
+		listing.Data.Children = append(listing.Data.Children, page.Data.Children...)
+	}
+	if err := pager.Err(); err != nil {
+		return nil, fmt.Errorf("fetch reddit listing %q: %w", src.Name, err)
+	}
+
+	title := src.Title
+	if title == "" {
+		title = redditTitle(src)
+	}
+	blk := listing.ToBlock(title, r.webURL)
+
+	if src.Comments > 0 {
+		r.fetchAllComments(ctx, listing, blk.Stories, src.Comments)
+	}
+
+	return []models.Block{blk}, nil
+}
+
+// fetchAllComments sets the comments of each story from its post, fetching
+// up to redditCommentConcurrency posts' comments at once
+func (r *RedditClient) fetchAllComments(ctx context.Context, listing RedditListingResponse, stories []models.Story, numComments int) {
+	slots := make(chan struct{}, redditCommentConcurrency)
+	var wg sync.WaitGroup
+	for i, post := range listing.Data.Children {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, subreddit, id, permalink string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			comments, err := r.FetchComments(ctx, subreddit, id, numComments)
+			if err != nil {
+				// Comments are a nice to have, so don't lose the whole block
+				log.Printf("Failed to fetch comments for %q: %s", permalink, err)
+				return
+			}
+			stories[i].Comments = comments
+		}(i, post.Data.Subreddit, post.Data.ID, post.Data.Permalink)
+	}
+	wg.Wait()
+}
+
+var _ ContextProvider = &RedditClient{}
 
+// ToBlock converts to a block, linking discussions to the given site, e.g.
+// https://old.reddit.com
+func (l RedditListingResponse) ToBlock(title, webURL string) models.Block {
 	block := models.Block{
 		Title: title,
 	}
 	for _, post := range l.Data.Children {
-		commentsURL.Path = post.Data.Permalink
+		commentsURL := strings.TrimSuffix(webURL, "/") + post.Data.Permalink
 
-		linkURL, err := url.Parse(post.Data.URL)
+		linkURL, err := url.Parse(canonicalURL(post.Data.URL))
 		if err != nil {
 			log.Printf("Failed to parse Link %q: %s", post.Data.URL, err)
+			linkURL = &url.URL{}
 		}
 
 		story := models.Story{
+			ID:           post.Data.Name,
 			Title:        post.Data.Title,
 			Link:         linkURL.String(),
 			Hostname:     linkURL.Host,
-			CommentsLink: commentsURL.String(),
+			CommentsLink: commentsURL,
 			NumComments:  post.Data.NumComments,
+			Score:        post.Data.Score,
 			Subreddit:    "r/" + post.Data.Subreddit,
 			Text:         post.Data.Selftext,
 		}
+
+		// Don't show images from posts which are blurred on reddit
+		if !post.Data.Over18 && !post.Data.Spoiler {
+			switch {
+			case post.Data.IsGallery && len(post.Data.GalleryData.Items) > 0:
+				media := post.Data.MediaMetadata[post.Data.GalleryData.Items[0].MediaID]
+				if media.Status == "valid" {
+					sizes := []RedditImage{}
+					for _, img := range media.P {
+						sizes = append(sizes, RedditImage(img))
+					}
+					story.Image = redditImageURL(RedditImage(media.S), sizes)
+				}
+			case len(post.Data.Preview.Images) > 0:
+				// Video posts have a preview of their first frame
+				img := post.Data.Preview.Images[0]
+				story.Image = redditImageURL(img.Source, img.Resolutions)
+			case strings.HasPrefix(post.Data.Thumbnail, "https://"):
+				story.Image = post.Data.Thumbnail
+			}
+		}
+
 		block.Stories = append(block.Stories, story)
 	}
 
 	return block
 }
 
+// redditImageURL picks the smallest size that's at least redditImageWidth
+// wide, falling back to the source image
+func redditImageURL(source RedditImage, sizes []RedditImage) string {
+	for _, img := range sizes {
+		if img.Width >= redditImageWidth {
+			return img.URL
+		}
+	}
+	return source.URL
+}
+
+// keep keeps up to n stories which pass the filter and were created after t,
+// if it's set
+func (l *RedditListingResponse) keep(f models.FilterMatcher, t time.Time, n int) {
+	children := l.Data.Children[:0]
+	for _, post := range l.Data.Children {
+		if len(children) == n {
+			break
+		}
+
+		created := time.Unix(int64(post.Data.CreatedUtc), 0)
+		flair, _ := post.Data.LinkFlairText.(string)
+		item := models.FilterItem{
+			Title:       post.Data.Title,
+			Text:        post.Data.Selftext,
+			Domain:      post.Data.Domain,
+			Flair:       flair,
+			Score:       post.Data.Score,
+			NumComments: post.Data.NumComments,
+			UpvoteRatio: post.Data.UpvoteRatio,
+			NSFW:        post.Data.Over18,
+			Spoiler:     post.Data.Spoiler,
+			Stickied:    post.Data.Stickied,
+		}
+		if (t.IsZero() || created.After(t)) && f.Match(item) {
+			children = append(children, post)
+		}
+	}
+	l.Data.Children = children
+}
+
+// RedditCommentListing is a listing of comments on a post
+type RedditCommentListing struct {
+	Kind string `json:"kind"`
+	Data struct {
+		Children []struct {
+			Kind string `json:"kind"`
+			Data struct {
+				Author    string `json:"author"`
+				Body      string `json:"body"`
+				Score     int    `json:"score"`
+				Permalink string `json:"permalink"`
+				Stickied  bool   `json:"stickied"`
+			} `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+}
+
+// RedditImage is a size of a post's preview image
+type RedditImage struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// RedditGalleryImage is a size of a gallery image
+type RedditGalleryImage struct {
+	URL    string `json:"u"`
+	Width  int    `json:"x"`
+	Height int    `json:"y"`
+}
+
 // RedditListingResponse is the response from a subreddit listing
 type RedditListingResponse struct {
 	Kind string `json:"kind"`
@@ -264,17 +857,9 @@ type RedditListingResponse struct {
 				Over18              bool        `json:"over_18"`
 				Preview             struct {
 					Images []struct {
-						Source struct {
-							URL    string `json:"url"`
-							Width  int    `json:"width"`
-							Height int    `json:"height"`
-						} `json:"source"`
-						Resolutions []struct {
-							URL    string `json:"url"`
-							Width  int    `json:"width"`
-							Height int    `json:"height"`
-						} `json:"resolutions"`
-						Variants struct {
+						Source      RedditImage   `json:"source"`
+						Resolutions []RedditImage `json:"resolutions"`
+						Variants    struct {
 						} `json:"variants"`
 						ID string `json:"id"`
 					} `json:"images"`
@@ -356,6 +941,17 @@ type RedditListingResponse struct {
 				NumCrossposts            int           `json:"num_crossposts"`
 				Media                    interface{}   `json:"media"`
 				IsVideo                  bool          `json:"is_video"`
+				IsGallery                bool          `json:"is_gallery"`
+				GalleryData              struct {
+					Items []struct {
+						MediaID string `json:"media_id"`
+					} `json:"items"`
+				} `json:"gallery_data"`
+				MediaMetadata map[string]struct {
+					Status string               `json:"status"`
+					S      RedditGalleryImage   `json:"s"`
+					P      []RedditGalleryImage `json:"p"`
+				} `json:"media_metadata"`
 			} `json:"data"`
 		} `json:"children"`
 		After  string      `json:"after"`
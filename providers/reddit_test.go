@@ -0,0 +1,371 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/hebo/mailshine/providers/reddittest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedditListingResponse_ToBlockImages(t *testing.T) {
+	listing := RedditListingResponse{}
+	err := json.Unmarshal([]byte(`{"data": {"children": [
+		{"data": {"title": "Preview", "url": "https://i.redd.it/a.jpg", "preview": {"images": [{
+			"source": {"url": "https://preview.redd.it/a.jpg", "width": 3000},
+			"resolutions": [
+				{"url": "https://preview.redd.it/a.jpg?width=320", "width": 320},
+				{"url": "https://preview.redd.it/a.jpg?width=960", "width": 960},
+				{"url": "https://preview.redd.it/a.jpg?width=1080", "width": 1080}
+			]}]}}},
+		{"data": {"title": "Gallery", "url": "https://www.reddit.com/gallery/b", "is_gallery": true,
+			"gallery_data": {"items": [{"media_id": "m1"}, {"media_id": "m2"}]},
+			"media_metadata": {
+				"m1": {"status": "valid", "s": {"u": "https://preview.redd.it/m1.jpg", "x": 500}, "p": [
+					{"u": "https://preview.redd.it/m1.jpg?width=108", "x": 108}
+				]},
+				"m2": {"status": "valid", "s": {"u": "https://preview.redd.it/m2.jpg", "x": 500}}
+			}}},
+		{"data": {"title": "NSFW", "url": "https://i.redd.it/c.jpg", "over_18": true,
+			"thumbnail": "https://b.thumbs.redditmedia.com/c.jpg"}},
+		{"data": {"title": "Self", "url": "https://www.reddit.com/r/x/comments/d", "thumbnail": "self"}}
+	]}}`), &listing)
+	require.NoError(t, err)
+
+	blk := listing.ToBlock("r/x", redditBaseURL)
+	require.Len(t, blk.Stories, 4)
+	require.Equal(t, "https://preview.redd.it/a.jpg?width=960", blk.Stories[0].Image)
+	require.Equal(t, "https://preview.redd.it/m1.jpg", blk.Stories[1].Image)
+	require.Empty(t, blk.Stories[2].Image)
+	require.Empty(t, blk.Stories[3].Image)
+}
+
+func Test_redditListingPath(t *testing.T) {
+	tests := []struct {
+		name, query, sort string
+		wantPath          string
+		wantParams        string
+	}{
+		{"golang", "", "", "r/golang/top", "t=week"},
+		{"golang", "", "new", "r/golang/new", ""},
+		{models.RedditFrontPage, "", "best", "best", ""},
+		{"user/spez/m/news", "", "hot", "user/spez/m/news/hot", ""},
+		{"u/spez", "", "top", "user/spez/submitted", "sort=top&t=week"},
+		{"sanfrancisco", "restaurant", "", "r/sanfrancisco/search", "q=restaurant&restrict_sr=1&sort=relevance&t=week"},
+		{models.RedditFrontPage, "golang", "new", "search", "q=golang&sort=new&t=week"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.query, func(t *testing.T) {
+			path, params, err := redditListingPath(tt.name, tt.query, tt.sort, "week")
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPath, path)
+			require.Equal(t, tt.wantParams, params.Encode())
+		})
+	}
+
+	_, _, err := redditListingPath("user/spez/m", "", "", "week")
+	require.Error(t, err)
+}
+
+func Test_redditTitle(t *testing.T) {
+	require.Equal(t, "r/golang", redditTitle(models.Source{Name: "golang"}))
+	require.Equal(t, "Reddit", redditTitle(models.Source{Name: models.RedditFrontPage}))
+	require.Equal(t, "m/news", redditTitle(models.Source{Name: "user/spez/m/news"}))
+	require.Equal(t, "u/spez", redditTitle(models.Source{Name: "user/spez"}))
+	require.Equal(t, `r/sanfrancisco: "restaurant"`, redditTitle(models.Source{Name: "sanfrancisco", Query: "restaurant"}))
+}
+
+func TestRedditListingResponse_keep(t *testing.T) {
+	listing := RedditListingResponse{}
+	err := json.Unmarshal([]byte(`{"data": {"children": [
+		{"data": {"title": "Weekly thread", "stickied": true, "score": 5, "created_utc": 1600000300}},
+		{"data": {"title": "Old", "score": 900, "created_utc": 1500000000}},
+		{"data": {"title": "Low", "score": 3, "created_utc": 1600000200}},
+		{"data": {"title": "First", "score": 400, "link_flair_text": "News", "created_utc": 1600000100}},
+		{"data": {"title": "Second", "score": 300, "link_flair_text": null, "created_utc": 1600000100}},
+		{"data": {"title": "Third", "score": 200, "created_utc": 1600000100}}
+	]}}`), &listing)
+	require.NoError(t, err)
+
+	excludeStickied := true
+	filter, err := models.Filter{MinScore: 10, ExcludeStickied: &excludeStickied, ExcludeFlairs: []string{"meta"}}.Matcher()
+	require.NoError(t, err)
+	listing.keep(filter, time.Unix(1600000000, 0), 2)
+
+	blk := listing.ToBlock("r/x", redditBaseURL)
+	require.Len(t, blk.Stories, 2)
+	require.Equal(t, "First", blk.Stories[0].Title)
+	require.Equal(t, "Second", blk.Stories[1].Title)
+}
+
+func TestRedditClient_do(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/flaky":
+			if calls < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("X-Ratelimit-Remaining", "0")
+			w.Header().Set("X-Ratelimit-Reset", "0.05")
+			fmt.Fprint(w, `{"kind": "Listing"}`)
+		case "/private":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"reason": "private", "message": "Forbidden", "error": 403}`)
+		case "/limited":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewRedditClient("id", "secret")
+	require.NoError(t, err)
+	client.backoff = time.Millisecond
+	get := func(path string) func(context.Context) (*http.Request, error) {
+		return func(ctx context.Context) (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", srv.URL+path, nil)
+		}
+	}
+
+	res := RedditListingResponse{}
+	err = client.do(context.Background(), get("/flaky"), &res)
+	require.NoError(t, err)
+	require.Equal(t, "Listing", res.Kind)
+	require.Equal(t, 3, calls)
+	require.True(t, client.pausedUntil.After(time.Now()))
+
+	err = client.do(context.Background(), get("/private"), &res)
+	require.True(t, errors.Is(err, ErrSubredditUnavailable))
+	require.Contains(t, err.Error(), "private")
+
+	err = client.do(context.Background(), get("/limited"), &res)
+	require.True(t, errors.Is(err, ErrRedditRateLimited))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.do(ctx, get("/flaky"), &res)
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestRedditListingResponse_ToBlock(t *testing.T) {
+	data, err := ioutil.ReadFile("../resources/listing_response.json")
+	require.NoError(t, err)
+	listing := RedditListingResponse{}
+	require.NoError(t, json.Unmarshal(data, &listing))
+
+	blk := listing.ToBlock("r/gaming", redditBaseURL)
+	require.Len(t, blk.Stories, 2)
+	require.Equal(t, models.Story{
+		ID:           "t3_jy0nj5",
+		Title:        "Suck at Madden",
+		Link:         "https://i.imgur.com/Zot7deg.jpg",
+		Hostname:     "i.imgur.com",
+		CommentsLink: "https://old.reddit.com/r/gaming/comments/jy0nj5/suck_at_madden/",
+		NumComments:  1138,
+		Score:        91019,
+		Subreddit:    "r/gaming",
+		Image:        blk.Stories[0].Image,
+	}, blk.Stories[0])
+}
+
+func newFakeRedditClient(t *testing.T, fake *reddittest.Server) *RedditClient {
+	client, err := NewRedditClient(reddittest.ClientID, reddittest.ClientSecret,
+		WithBaseURL(fake.URL), WithTokenURL(fake.TokenURL()), WithWebURL(fake.URL))
+	require.NoError(t, err)
+	client.backoff = time.Millisecond
+	return client
+}
+
+func TestRedditClient_Fetch(t *testing.T) {
+	fake := reddittest.NewServer()
+	defer fake.Close()
+
+	now := time.Now()
+	for i := 0; i < 150; i++ {
+		fake.AddPosts(reddittest.Post{
+			ID:          fmt.Sprintf("p%d", i),
+			Subreddit:   "golang",
+			Title:       fmt.Sprintf("Post %d", i),
+			URL:         fmt.Sprintf("https://example.com/%d?utm_source=reddit", i),
+			Score:       1000 - i,
+			NumComments: i,
+			Created:     now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+	fake.AddPosts(reddittest.Post{
+		ID: "c1", Subreddit: "asksf", Title: "Best burrito?", Score: 50,
+		Comments: []reddittest.Comment{
+			{Author: "mod", Body: "Rules", Stickied: true},
+			{Author: "a", Body: "La Taqueria", Score: 30},
+			{Author: "b", Body: "El Farolito", Score: 20},
+			{Author: "c", Body: "Papalote", Score: 10},
+		},
+	})
+	fake.SetError("private", http.StatusForbidden, "private")
+	client := newFakeRedditClient(t, fake)
+
+	// Only every tenth post passes, so it takes two pages to fill the block
+	blocks, err := client.Fetch(models.Source{
+		Type: models.SourceTypeReddit, Name: "golang", NumItems: 12, TimePeriod: "day",
+		Filter: models.Filter{Include: []string{`Post \d*0$`}},
+	})
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "r/golang", blocks[0].Title)
+	require.Len(t, blocks[0].Stories, 12)
+	require.Equal(t, "Post 110", blocks[0].Stories[11].Title)
+	require.Equal(t, "https://example.com/0", blocks[0].Stories[0].Link)
+	require.Equal(t, fake.URL+"/r/golang/comments/p0/", blocks[0].Stories[0].CommentsLink)
+	require.Len(t, fake.Requests(), 2)
+	require.Contains(t, fake.Requests()[1], "after=t3_p99")
+
+	blocks, err = client.Fetch(models.Source{
+		Type: models.SourceTypeReddit, Name: "asksf", NumItems: 5, TimePeriod: "day", Comments: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []models.Comment{
+		{Author: "u/a", Text: "La Taqueria", Score: 30, Link: fake.URL + "/r/asksf/comments/c1/c1/"},
+		{Author: "u/b", Text: "El Farolito", Score: 20, Link: fake.URL + "/r/asksf/comments/c1/c2/"},
+	}, blocks[0].Stories[0].Comments)
+
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeReddit, Name: "private", NumItems: 5, TimePeriod: "day"})
+	require.True(t, errors.Is(err, ErrSubredditUnavailable))
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeReddit, Name: "missing", NumItems: 5, TimePeriod: "day"})
+	require.True(t, errors.Is(err, ErrSubredditUnavailable))
+
+	// A revoked token is replaced, and server errors are retried
+	fake.RevokeToken()
+	fake.FailNext(http.StatusBadGateway)
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeReddit, Name: "asksf", NumItems: 5, TimePeriod: "day"})
+	require.NoError(t, err)
+	require.Equal(t, 2, fake.TokenIssues())
+}
+
+func TestRedditClient_FetchComments(t *testing.T) {
+	fake := reddittest.NewServer()
+	defer fake.Close()
+
+	comments := []reddittest.Comment{
+		{Author: "mod", Body: "Rules", Stickied: true},
+		{Author: "a", Body: "First<img src=x onerror=alert(1)>", Score: 30},
+		{Author: "b", Body: "Second", Score: 20},
+	}
+	for i := 0; i < 5; i++ {
+		fake.AddPosts(reddittest.Post{
+			ID: fmt.Sprintf("p%d", i), Subreddit: "golang", Title: fmt.Sprintf("Post %d", i), Comments: comments,
+		})
+	}
+	fake.AddPosts(reddittest.Post{ID: "q0", Subreddit: "quarantined", Title: "Hidden", Comments: comments})
+	fake.SetError("quarantined", http.StatusForbidden, "quarantined")
+	fake.SetLatency(20 * time.Millisecond)
+	client := newFakeRedditClient(t, fake)
+
+	blocks, err := client.Fetch(models.Source{Type: models.SourceTypeReddit, Name: "golang+quarantined", NumItems: 6, TimePeriod: "day", Comments: 2})
+	require.NoError(t, err)
+	require.Len(t, blocks[0].Stories, 6)
+	require.Equal(t, []models.Comment{
+		{Author: "u/a", Text: "First", Score: 30, Link: fake.URL + "/r/golang/comments/p0/c1/"},
+		{Author: "u/b", Text: "Second", Score: 20, Link: fake.URL + "/r/golang/comments/p0/c2/"},
+	}, blocks[0].Stories[0].Comments)
+	require.Contains(t, fake.Requests(), "/r/golang/comments/p4?depth=1&limit=3&raw_json=1&sort=top")
+
+	// A post whose comments fail keeps its story, and comments are fetched
+	// concurrently, up to the limit
+	require.Empty(t, blocks[0].Stories[5].Comments)
+	require.Equal(t, "Hidden", blocks[0].Stories[5].Title)
+	require.Equal(t, redditCommentConcurrency, fake.MaxInFlight())
+}
+
+func TestRedditPager(t *testing.T) {
+	fake := reddittest.NewServer()
+	defer fake.Close()
+
+	now := time.Now()
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		fake.AddPosts(reddittest.Post{ID: id, Subreddit: "golang", Created: now.Add(-time.Duration(i) * time.Minute)})
+	}
+	fake.SetError("private", http.StatusForbidden, "private")
+	client := newFakeRedditClient(t, fake)
+
+	pager, err := client.ListingPager(context.Background(), "golang", "", "new", "day", 2, 5)
+	require.NoError(t, err)
+	var names []string
+	for pager.Next() {
+		for _, post := range pager.Page().Data.Children {
+			names = append(names, post.Data.Name)
+		}
+	}
+	require.NoError(t, pager.Err())
+	require.Equal(t, []string{"t3_a", "t3_b", "t3_c", "t3_d", "t3_e"}, names)
+	// Each page continues from the previous one, with the number already
+	// seen, and the end of the listing has no cursor
+	var cursors []string
+	for _, req := range fake.Requests() {
+		u, err := url.Parse(req)
+		require.NoError(t, err)
+		require.Equal(t, "/r/golang/new", u.Path)
+		require.Equal(t, "2", u.Query().Get("limit"))
+		cursors = append(cursors, u.Query().Get("after")+"/"+u.Query().Get("count"))
+	}
+	require.Equal(t, []string{"/", "t3_b/2", "t3_d/4"}, cursors)
+	require.False(t, pager.Next())
+
+	// The page budget stops paging early
+	before := len(fake.Requests())
+	pager, err = client.ListingPager(context.Background(), "golang", "", "new", "day", 2, 2)
+	require.NoError(t, err)
+	pages := 0
+	for pager.Next() {
+		pages++
+	}
+	require.NoError(t, pager.Err())
+	require.Equal(t, 2, pages)
+	require.Len(t, fake.Requests(), before+2)
+
+	// Errors stop paging
+	pager, err = client.ListingPager(context.Background(), "private", "", "new", "day", 2, 5)
+	require.NoError(t, err)
+	require.False(t, pager.Next())
+	require.True(t, errors.Is(pager.Err(), ErrSubredditUnavailable))
+	require.False(t, pager.Next())
+
+	_, err = client.ListingPager(context.Background(), "not/a/source", "", "new", "day", 2, 2)
+	require.Error(t, err)
+}
+
+func TestRedditClient_FetchAuthFailed(t *testing.T) {
+	fake := reddittest.NewServer()
+	defer fake.Close()
+
+	client, err := NewRedditClient("wrong", "creds", WithBaseURL(fake.URL), WithTokenURL(fake.TokenURL()))
+	require.NoError(t, err)
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeReddit, Name: "golang", NumItems: 5, TimePeriod: "day"})
+	require.True(t, errors.Is(err, ErrRedditAuth))
+}
+
+func TestRedditClient_FetchRateLimited(t *testing.T) {
+	fake := reddittest.NewServer()
+	defer fake.Close()
+	fake.AddPosts(reddittest.Post{ID: "a", Subreddit: "golang", Title: "A"})
+	client := newFakeRedditClient(t, fake)
+
+	fake.SetRateLimit(0, time.Hour)
+	_, err := client.Fetch(models.Source{Type: models.SourceTypeReddit, Name: "golang", NumItems: 5, TimePeriod: "day"})
+	require.NoError(t, err)
+
+	// The limit is used up, and resets too far in the future to wait for
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeReddit, Name: "golang", NumItems: 5, TimePeriod: "day"})
+	require.True(t, errors.Is(err, ErrRedditRateLimited))
+}
@@ -0,0 +1,429 @@
+// Package reddittest provides a fake Reddit for tests. It issues OAuth tokens,
+// and serves listings, searches and comments for the posts it's given, with
+// configurable errors and rate limits
+package reddittest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ClientID and ClientSecret are the credentials the server accepts
+	ClientID     = "client-id"
+	ClientSecret = "client-secret"
+
+	token     = "fake-token"
+	tokenPath = "/api/v1/access_token"
+)
+
+// Post is a post on the fake Reddit. Listings return posts in the order
+// they're added, except the "new" sort, which orders them by Created
+type Post struct {
+	ID          string
+	Subreddit   string
+	Author      string
+	Title       string
+	URL         string
+	Selftext    string
+	Flair       string
+	Score       int
+	NumComments int
+	UpvoteRatio float64
+	Created     time.Time
+	NSFW        bool
+	Stickied    bool
+	Comments    []Comment
+}
+
+// Comment is a top level comment on a post
+type Comment struct {
+	Author   string
+	Body     string
+	Score    int
+	Stickied bool
+}
+
+type listingError struct {
+	status int
+	reason string
+}
+
+// Server is a fake Reddit. Point a client at it with its URL as the API and
+// web URLs, and TokenURL as the token URL
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	posts       []Post
+	errors      map[string]listingError
+	failures    []int
+	remaining   float64
+	reset       time.Duration
+	requests    []string
+	tokenIssues int
+	revoked     bool
+	latency     time.Duration
+	inFlight    int
+	maxInFlight int
+}
+
+// NewServer starts a fake Reddit, which the caller should Close
+func NewServer() *Server {
+	s := &Server{
+		errors:    map[string]listingError{},
+		remaining: 600,
+		reset:     10 * time.Minute,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// TokenURL is the URL of the OAuth token endpoint
+func (s *Server) TokenURL() string {
+	return s.URL + tokenPath
+}
+
+// AddPosts adds posts to their subreddits
+func (s *Server) AddPosts(posts ...Post) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.posts = append(s.posts, posts...)
+}
+
+// SetError makes requests for a subreddit fail with a status and reason, like
+// "private" with http.StatusForbidden or "banned" with http.StatusNotFound
+func (s *Server) SetError(subreddit string, status int, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[strings.ToLower(subreddit)] = listingError{status, reason}
+}
+
+// FailNext makes the next API requests fail, one for each status
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// SetRateLimit sets the X-Ratelimit headers of API responses
+func (s *Server) SetRateLimit(remaining float64, reset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remaining = remaining
+	s.reset = reset
+}
+
+// RevokeToken makes the issued token invalid, until a new one is issued
+func (s *Server) RevokeToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked = true
+}
+
+// SetLatency delays API responses, so concurrent requests overlap
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// MaxInFlight is the most API requests the server has handled at once
+func (s *Server) MaxInFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxInFlight
+}
+
+// Requests returns the path and query of each API request, in order
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// TokenIssues is the number of tokens the server has issued
+func (s *Server) TokenIssues() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenIssues
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == tokenPath {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.issueToken(w, r)
+		return
+	}
+
+	// Requests wait out the latency together, then are handled one at a time
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	latency := s.latency
+	s.mu.Unlock()
+	time.Sleep(latency)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() { s.inFlight-- }()
+
+	s.requests = append(s.requests, r.URL.RequestURI())
+	if s.revoked || r.Header.Get("Authorization") != "bearer "+token {
+		writeError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	w.Header().Set("X-Ratelimit-Used", "1")
+	w.Header().Set("X-Ratelimit-Remaining", strconv.FormatFloat(s.remaining, 'f', 1, 64))
+	w.Header().Set("X-Ratelimit-Reset", strconv.Itoa(int(s.reset.Seconds())))
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, status, "")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	switch {
+	case len(parts) == 4 && parts[0] == "r" && parts[2] == "comments":
+		s.serveComments(w, parts[1], parts[3])
+	case len(parts) == 3 && parts[0] == "r":
+		if e, ok := s.errors[strings.ToLower(parts[1])]; ok {
+			writeError(w, e.status, e.reason)
+			return
+		}
+		posts := s.subredditPosts(parts[1])
+		if len(posts) == 0 {
+			// Reddit redirects to a search for subreddits which don't exist
+			http.Redirect(w, r, "/subreddits/search?q="+url.QueryEscape(parts[1]), http.StatusFound)
+			return
+		}
+		if parts[2] == "search" {
+			posts = search(posts, q.Get("q"))
+			writeListing(w, sortPosts(posts, q.Get("sort")), q)
+			return
+		}
+		writeListing(w, sortPosts(posts, parts[2]), q)
+	case len(parts) == 3 && parts[0] == "user" && parts[2] == "submitted":
+		var posts []Post
+		for _, p := range s.posts {
+			if strings.EqualFold(p.Author, parts[1]) {
+				posts = append(posts, p)
+			}
+		}
+		writeListing(w, sortPosts(posts, q.Get("sort")), q)
+	case len(parts) == 1 && parts[0] == "search":
+		writeListing(w, sortPosts(search(s.posts, q.Get("q")), q.Get("sort")), q)
+	case len(parts) == 1:
+		writeListing(w, sortPosts(s.posts, parts[0]), q)
+	default:
+		writeError(w, http.StatusNotFound, "")
+	}
+}
+
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != ClientID || secret != ClientSecret {
+		writeError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	s.tokenIssues++
+	s.revoked = false
+	writeJSON(w, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "bearer",
+		"expires_in":   3600,
+	})
+}
+
+// subredditPosts returns the posts of a subreddit, or several joined by +
+func (s *Server) subredditPosts(name string) []Post {
+	var posts []Post
+	for _, sub := range strings.Split(name, "+") {
+		for _, p := range s.posts {
+			if strings.EqualFold(p.Subreddit, sub) {
+				posts = append(posts, p)
+			}
+		}
+	}
+	return posts
+}
+
+func (s *Server) serveComments(w http.ResponseWriter, subreddit, id string) {
+	if e, ok := s.errors[strings.ToLower(subreddit)]; ok {
+		writeError(w, e.status, e.reason)
+		return
+	}
+	for _, p := range s.posts {
+		if p.ID != id || !strings.EqualFold(p.Subreddit, subreddit) {
+			continue
+		}
+
+		var children []interface{}
+		for i, c := range p.Comments {
+			children = append(children, map[string]interface{}{
+				"kind": "t1",
+				"data": map[string]interface{}{
+					"author":    c.Author,
+					"body":      c.Body,
+					"score":     c.Score,
+					"stickied":  c.Stickied,
+					"permalink": fmt.Sprintf("%sc%d/", permalink(p), i),
+				},
+			})
+		}
+		// Reddit ends comment listings with a placeholder for the rest
+		children = append(children, map[string]interface{}{
+			"kind": "more",
+			"data": map[string]interface{}{"count": 10},
+		})
+
+		writeJSON(w, []interface{}{
+			listing([]interface{}{postThing(p)}, ""),
+			listing(children, ""),
+		})
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "")
+}
+
+// writeListing writes a page of posts, following Reddit's limit and after parameters
+func writeListing(w http.ResponseWriter, posts []Post, q url.Values) {
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 25
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	start := 0
+	if after := q.Get("after"); after != "" {
+		for i, p := range posts {
+			if "t3_"+p.ID == after {
+				start = i + 1
+			}
+		}
+	}
+	end := start + limit
+	if end > len(posts) {
+		end = len(posts)
+	}
+
+	var children []interface{}
+	for _, p := range posts[start:end] {
+		children = append(children, postThing(p))
+	}
+	after := ""
+	if end < len(posts) {
+		after = "t3_" + posts[end-1].ID
+	}
+	writeJSON(w, listing(children, after))
+}
+
+func listing(children []interface{}, after string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind": "Listing",
+		"data": map[string]interface{}{
+			"dist":     len(children),
+			"children": children,
+			"after":    after,
+		},
+	}
+}
+
+func postThing(p Post) map[string]interface{} {
+	link := p.URL
+	domain := "self." + p.Subreddit
+	if link == "" {
+		link = "https://www.reddit.com" + permalink(p)
+	} else if u, err := url.Parse(link); err == nil {
+		domain = strings.TrimPrefix(u.Host, "www.")
+	}
+
+	var flair interface{}
+	if p.Flair != "" {
+		flair = p.Flair
+	}
+	return map[string]interface{}{
+		"kind": "t3",
+		"data": map[string]interface{}{
+			"id":              p.ID,
+			"name":            "t3_" + p.ID,
+			"subreddit":       p.Subreddit,
+			"author":          p.Author,
+			"title":           p.Title,
+			"url":             link,
+			"domain":          domain,
+			"selftext":        p.Selftext,
+			"is_self":         p.URL == "",
+			"link_flair_text": flair,
+			"score":           p.Score,
+			"ups":             p.Score,
+			"num_comments":    p.NumComments,
+			"upvote_ratio":    p.UpvoteRatio,
+			"created_utc":     float64(p.Created.Unix()),
+			"over_18":         p.NSFW,
+			"stickied":        p.Stickied,
+			"permalink":       permalink(p),
+		},
+	}
+}
+
+func permalink(p Post) string {
+	return fmt.Sprintf("/r/%s/comments/%s/", p.Subreddit, p.ID)
+}
+
+// sortPosts orders posts by Created for the "new" sort
+func sortPosts(posts []Post, sortBy string) []Post {
+	if sortBy != "new" {
+		return posts
+	}
+	sorted := append([]Post{}, posts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Created.After(sorted[j].Created)
+	})
+	return sorted
+}
+
+// search returns the posts with the query in their title or text
+func search(posts []Post, query string) []Post {
+	query = strings.ToLower(query)
+	var found []Post
+	for _, p := range posts {
+		if strings.Contains(strings.ToLower(p.Title+" "+p.Selftext), query) {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+func writeError(w http.ResponseWriter, status int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	body := map[string]interface{}{"message": http.StatusText(status), "error": status}
+	if reason != "" {
+		body["reason"] = reason
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
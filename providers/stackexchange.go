@@ -0,0 +1,246 @@
+package providers
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+)
+
+const stackExchangeAPIURL = "https://api.stackexchange.com/2.3/"
+
+// NewStackExchangeClient creates a new StackExchangeClient. The key is
+// optional, but raises the daily request quota
+func NewStackExchangeClient(key string, opts ...Option) *StackExchangeClient {
+	return &StackExchangeClient{
+		clientOptions: newClientOptions(stackExchangeAPIURL, opts),
+		key:           key,
+	}
+}
+
+// StackExchangeClient interfaces with the Stack Exchange API
+type StackExchangeClient struct {
+	clientOptions
+	key string
+
+	mu sync.Mutex // guards backoffUntil
+	// backoffUntil is when the API last asked us to wait until
+	backoffUntil time.Time
+}
+
+// stackExchangeWrapper is the envelope of every API response, including errors
+type stackExchangeWrapper struct {
+	Backoff        int    `json:"backoff"`
+	QuotaRemaining int    `json:"quota_remaining"`
+	ErrorID        int    `json:"error_id"`
+	ErrorName      string `json:"error_name"`
+	ErrorMessage   string `json:"error_message"`
+}
+
+// stackExchangeThrottled is the error ID for too many requests, whose message
+// says how long to wait, e.g. "more requests available in 72 seconds"
+const stackExchangeThrottled = 502
+
+var stackExchangeWaitRE = regexp.MustCompile(`available in (\d+) seconds`)
+
+func (w stackExchangeWrapper) wrapper() stackExchangeWrapper { return w }
+
+// stackExchangeResponse is any response embedding stackExchangeWrapper
+type stackExchangeResponse interface {
+	wrapper() stackExchangeWrapper
+}
+
+// StackExchangeQuestion is a single question
+type StackExchangeQuestion struct {
+	QuestionID       int      `json:"question_id"`
+	Title            string   `json:"title"`
+	Link             string   `json:"link"`
+	Score            int      `json:"score"`
+	AnswerCount      int      `json:"answer_count"`
+	AcceptedAnswerID int      `json:"accepted_answer_id"`
+	Tags             []string `json:"tags"`
+
+	// AcceptedAnswer is the body of the accepted answer, if it was fetched
+	AcceptedAnswer string `json:"-"`
+}
+
+// call requests an API method, honouring any backoff the API asked for,
+// in successful responses or errors
+func (s *StackExchangeClient) call(method string, params url.Values, v stackExchangeResponse) error {
+	if s.key != "" {
+		params.Set("key", s.key)
+	}
+	methodURL := s.baseURL + method + "?" + params.Encode()
+
+	s.mu.Lock()
+	wait := time.Until(s.backoffUntil)
+	s.mu.Unlock()
+	if wait > 0 {
+		log.Printf("Stack Exchange asked us to back off, waiting %s", wait)
+		time.Sleep(wait)
+	}
+
+	log.Printf("Making request to %q\n", methodURL)
+	req, err := http.NewRequest("GET", methodURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-agent", userAgent)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Errors have the same envelope, so decode it to get their backoff
+	err = decodeJSON(resp.Body, v)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return err
+	}
+	w := v.wrapper()
+
+	backoff := time.Duration(w.Backoff) * time.Second
+	if w.ErrorID == stackExchangeThrottled {
+		if m := stackExchangeWaitRE.FindStringSubmatch(w.ErrorMessage); m != nil {
+			seconds, _ := strconv.Atoi(m[1])
+			backoff = time.Duration(seconds) * time.Second
+		}
+	}
+	if backoff > 0 {
+		s.mu.Lock()
+		s.backoffUntil = time.Now().Add(backoff)
+		s.mu.Unlock()
+	}
+
+	if resp.StatusCode != http.StatusOK || w.ErrorID != 0 {
+		return fmt.Errorf("unexpected status %q from %s: %s: %s", resp.Status, methodURL, w.ErrorName, w.ErrorMessage)
+	}
+	return nil
+}
+
+// FetchQuestions fetches the top voted questions on a site with the given
+// tags, asked after since. A zero since fetches questions from any time
+func (s *StackExchangeClient) FetchQuestions(site, tags string, since time.Time, numQuestions int) ([]StackExchangeQuestion, error) {
+	params := url.Values{}
+	params.Add("site", site)
+	params.Add("tagged", tags)
+	params.Add("sort", "votes")
+	params.Add("order", "desc")
+	if !since.IsZero() {
+		params.Add("fromdate", strconv.FormatInt(since.Unix(), 10))
+	}
+	params.Add("pagesize", strconv.Itoa(numQuestions))
+
+	res := struct {
+		stackExchangeWrapper
+		Items []StackExchangeQuestion `json:"items"`
+	}{}
+	err := s.call("questions", params, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Fetched %d questions from %s [%s]", len(res.Items), site, tags)
+	return res.Items, nil
+}
+
+// fetchAcceptedAnswers fills in the accepted answer of each question that has one
+func (s *StackExchangeClient) fetchAcceptedAnswers(site string, questions []StackExchangeQuestion) error {
+	var ids []string
+	for _, q := range questions {
+		if q.AcceptedAnswerID != 0 {
+			ids = append(ids, strconv.Itoa(q.AcceptedAnswerID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	params := url.Values{}
+	params.Add("site", site)
+	params.Add("filter", "withbody")
+	params.Add("pagesize", "100")
+
+	res := struct {
+		stackExchangeWrapper
+		Items []struct {
+			AnswerID int    `json:"answer_id"`
+			Body     string `json:"body"`
+		} `json:"items"`
+	}{}
+	err := s.call("answers/"+strings.Join(ids, ";"), params, &res)
+	if err != nil {
+		return err
+	}
+
+	bodies := make(map[int]string)
+	for _, a := range res.Items {
+		bodies[a.AnswerID] = a.Body
+	}
+	for i := range questions {
+		questions[i].AcceptedAnswer = bodies[questions[i].AcceptedAnswerID]
+	}
+	return nil
+}
+
+// Fetch fetches the top questions for a source, given as "tags@site", e.g.
+// "go@stackoverflow". Multiple tags are separated by semicolons
+func (s *StackExchangeClient) Fetch(src models.Source) ([]models.Block, error) {
+	tags, site := splitAt(src.Name)
+	if tags == "" || site == "" {
+		return nil, fmt.Errorf("invalid source %q, expected tags@site", src.Name)
+	}
+
+	questions, err := s.FetchQuestions(site, tags, src.Cutoff(), src.NumItems)
+	if err != nil {
+		return nil, err
+	}
+
+	if src.AcceptedAnswers {
+		err = s.fetchAcceptedAnswers(site, questions)
+		if err != nil {
+			return nil, fmt.Errorf("fetch accepted answers: %w", err)
+		}
+	}
+
+	title := src.Title
+	if title == "" {
+		title = fmt.Sprintf("%s [%s]", site, tags)
+	}
+
+	block := models.Block{Title: title}
+	for _, q := range questions {
+		block.Stories = append(block.Stories, q.ToStory())
+	}
+	return []models.Block{block}, nil
+}
+
+var _ Provider = &StackExchangeClient{}
+
+// ToStory converts to a story
+func (q StackExchangeQuestion) ToStory() models.Story {
+	linkURL, err := url.Parse(q.Link)
+	if err != nil {
+		log.Printf("Failed to parse Link %q: %s", q.Link, err)
+		linkURL = &url.URL{}
+	}
+
+	return models.Story{
+		// Titles are HTML encoded by the API
+		Title:        html.UnescapeString(q.Title),
+		Link:         linkURL.String(),
+		Hostname:     linkURL.Host,
+		CommentsLink: linkURL.String(),
+		NumComments:  q.AnswerCount,
+		Score:        q.Score,
+		Text:         htmlToMarkdown(q.AcceptedAnswer),
+	}
+}
@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackExchangeClient_Fetch(t *testing.T) {
+	now := time.Date(2020, 12, 3, 8, 0, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		require.Equal(t, "unix", q.Get("site"))
+		require.Equal(t, "secret", q.Get("key"))
+
+		switch r.URL.Path {
+		case "/2.3/questions":
+			require.Equal(t, "bash;awk", q.Get("tagged"))
+			require.Equal(t, "1606896000", q.Get("fromdate"))
+			w.Write([]byte(`{"items": [
+				{"question_id": 1, "title": "Why &quot;quote&quot;?", "link": "https://unix.stackexchange.com/q/1", "score": 12, "answer_count": 2, "accepted_answer_id": 10},
+				{"question_id": 2, "title": "Unanswered", "link": "https://unix.stackexchange.com/q/2", "score": 3}
+			], "quota_remaining": 9000}`))
+		case "/2.3/answers/10":
+			w.Write([]byte(`{"items": [{"answer_id": 10, "body": "<p>Because <code>sh</code></p>"}], "backoff": 10}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	se := NewStackExchangeClient("secret", WithBaseURL(ts.URL+"/2.3/"))
+	blocks, err := se.Fetch(models.Source{
+		Type:            models.SourceTypeStackExchange,
+		Name:            "bash;awk@unix",
+		NumItems:        2,
+		TimePeriod:      "day",
+		AcceptedAnswers: true,
+		Now:             now,
+	})
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "unix [bash;awk]", blocks[0].Title)
+	require.Equal(t, models.Story{
+		Title:        `Why "quote"?`,
+		Link:         "https://unix.stackexchange.com/q/1",
+		Hostname:     "unix.stackexchange.com",
+		CommentsLink: "https://unix.stackexchange.com/q/1",
+		NumComments:  2,
+		Score:        12,
+		Text:         "Because sh",
+	}, blocks[0].Stories[0])
+	require.Empty(t, blocks[0].Stories[1].Text)
+
+	// The backoff applies to the next request
+	require.WithinDuration(t, time.Now().Add(10*time.Second), se.backoffUntil, time.Second)
+}
+
+func TestStackExchangeClient_FetchThrottled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error_id": 502, "error_name": "throttle_violation",
+			"error_message": "too many requests from this IP, more requests available in 72 seconds"}`))
+	}))
+	defer ts.Close()
+
+	se := NewStackExchangeClient("", WithBaseURL(ts.URL+"/"))
+	_, err := se.Fetch(models.Source{Type: models.SourceTypeStackExchange, Name: "go@stackoverflow", NumItems: 5, TimePeriod: "day"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "throttle_violation")
+	require.WithinDuration(t, time.Now().Add(72*time.Second), se.backoffUntil, time.Second)
+}
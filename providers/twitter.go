@@ -0,0 +1,206 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+)
+
+const (
+	twitterAPIURL = "https://api.twitter.com/2/"
+	twitterWebURL = "https://twitter.com"
+)
+
+// NewTwitterClient creates a new TwitterClient
+func NewTwitterClient(bearerToken string, opts ...Option) (*TwitterClient, error) {
+	client := &TwitterClient{
+		clientOptions: newClientOptions(twitterAPIURL, opts),
+		bearerToken:   bearerToken,
+		userIDs:       make(map[string]string),
+	}
+	if bearerToken == "" {
+		return client, errors.New("missing bearer token")
+	}
+
+	return client, nil
+}
+
+// TwitterClient interfaces with the Twitter v2 API, or any API compatible with it
+type TwitterClient struct {
+	clientOptions
+	bearerToken string
+	mu          sync.Mutex        // guards userIDs
+	userIDs     map[string]string // username -> user ID
+}
+
+// Tweet is a single post from a timeline
+type Tweet struct {
+	ID            string    `json:"id"`
+	Text          string    `json:"text"`
+	CreatedAt     time.Time `json:"created_at"`
+	PublicMetrics struct {
+		RetweetCount int `json:"retweet_count"`
+		ReplyCount   int `json:"reply_count"`
+		LikeCount    int `json:"like_count"`
+		QuoteCount   int `json:"quote_count"`
+	} `json:"public_metrics"`
+	Entities struct {
+		URLs []struct {
+			URL         string `json:"url"`
+			ExpandedURL string `json:"expanded_url"`
+		} `json:"urls"`
+	} `json:"entities"`
+
+	// Username is the author's username, which isn't part of the API response
+	Username string `json:"-"`
+}
+
+// Engagement scores a tweet by its interactions. Shares count double, since
+// they spread a post further than a like does
+func (t Tweet) Engagement() int {
+	m := t.PublicMetrics
+	return m.LikeCount + m.ReplyCount + 2*(m.RetweetCount+m.QuoteCount)
+}
+
+func (t *TwitterClient) header() http.Header {
+	return http.Header{"Authorization": {"Bearer " + t.bearerToken}}
+}
+
+// userID looks up the ID for a username, which the timeline API requires
+func (t *TwitterClient) userID(username string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if id, ok := t.userIDs[username]; ok {
+		return id, nil
+	}
+
+	res := struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}{}
+	err := t.getJSON(t.baseURL+"users/by/username/"+url.PathEscape(username), t.header(), &res)
+	if err != nil {
+		return "", err
+	}
+	if res.Data.ID == "" {
+		return "", fmt.Errorf("user %q not found", username)
+	}
+
+	t.userIDs[username] = res.Data.ID
+	return res.Data.ID, nil
+}
+
+// FetchTimeline fetches an account's original posts since a point in time
+func (t *TwitterClient) FetchTimeline(username string, since time.Time) ([]Tweet, error) {
+	username = strings.TrimPrefix(username, "@")
+	id, err := t.userID(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	params := url.Values{}
+	if !since.IsZero() {
+		params.Add("start_time", since.UTC().Format(time.RFC3339))
+	}
+	params.Add("max_results", "100")
+	params.Add("exclude", "retweets,replies")
+	params.Add("tweet.fields", "created_at,public_metrics,entities")
+	timelineURL := t.baseURL + "users/" + url.PathEscape(id) + "/tweets?" + params.Encode()
+
+	res := struct {
+		Data []Tweet `json:"data"`
+	}{}
+	log.Printf("Making request to %q\n", timelineURL)
+	err = t.getJSON(timelineURL, t.header(), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range res.Data {
+		res.Data[i].Username = username
+	}
+	log.Printf("Fetched %d tweets from %q", len(res.Data), username)
+	return res.Data, nil
+}
+
+// Fetch fetches the most engaging posts of each account in the source, as
+// one block per account or a single merged block
+func (t *TwitterClient) Fetch(src models.Source) ([]models.Block, error) {
+	var blocks []models.Block
+	var merged []Tweet
+	for _, name := range src.AllNames() {
+		tweets, err := t.FetchTimeline(name, src.Cutoff())
+		if err != nil {
+			return nil, fmt.Errorf("fetch timeline %q: %w", name, err)
+		}
+
+		if src.Merge {
+			merged = append(merged, tweets...)
+			continue
+		}
+
+		title := src.Title
+		if title == "" {
+			title = "@" + strings.TrimPrefix(name, "@")
+		}
+		blocks = append(blocks, tweetsToBlock(title, tweets, src.NumItems))
+	}
+
+	if src.Merge {
+		title := src.Title
+		if title == "" {
+			title = "Twitter"
+		}
+		blocks = append(blocks, tweetsToBlock(title, merged, src.NumItems))
+	}
+
+	return blocks, nil
+}
+
+var _ Provider = &TwitterClient{}
+
+// tweetsToBlock converts the most engaging tweets to a block
+func tweetsToBlock(title string, tweets []Tweet, numItems int) models.Block {
+	var stories []models.Story
+	for _, tweet := range tweets {
+		stories = append(stories, tweet.ToStory())
+	}
+
+	return models.Block{Title: title, Stories: topStories(stories, numItems)}
+}
+
+// ToStory converts to a story
+func (t Tweet) ToStory() models.Story {
+	text := t.Text
+	for _, u := range t.Entities.URLs {
+		text = strings.ReplaceAll(text, u.URL, u.ExpandedURL)
+	}
+
+	story := models.Story{
+		ID:           "twitter/" + t.ID,
+		Author:       "@" + t.Username,
+		CommentsLink: fmt.Sprintf("%s/%s/status/%s", twitterWebURL, t.Username, t.ID),
+		NumComments:  t.PublicMetrics.ReplyCount,
+		Score:        t.Engagement(),
+		Text:         textToMarkdown(text),
+	}
+
+	// Link to the first URL in the post, if there is one
+	if len(t.Entities.URLs) > 0 {
+		linkURL, err := url.Parse(canonicalURL(t.Entities.URLs[0].ExpandedURL))
+		if err == nil {
+			story.Link = linkURL.String()
+			story.Hostname = linkURL.Host
+		}
+	}
+
+	return story
+}
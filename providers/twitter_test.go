@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwitterClient_Fetch(t *testing.T) {
+	now := time.Date(2020, 12, 3, 8, 0, 0, 0, time.UTC)
+	lookups := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/2/users/by/username/golang":
+			lookups++
+			w.Write([]byte(`{"data": {"id": "1"}}`))
+		case "/2/users/by/username/rob":
+			lookups++
+			w.Write([]byte(`{"data": {"id": "2"}}`))
+		case "/2/users/1/tweets":
+			require.Equal(t, "2020-12-02T08:00:00Z", r.URL.Query().Get("start_time"))
+			require.Equal(t, "retweets,replies", r.URL.Query().Get("exclude"))
+			w.Write([]byte(`{"data": [
+				{"id": "10", "text": "Go 1.16 is out! *Embed* files &amp; more https://t.co/a", "public_metrics": {"like_count": 100, "retweet_count": 20, "reply_count": 5},
+				 "entities": {"urls": [{"url": "https://t.co/a", "expanded_url": "https://blog.golang.org/go1.16?utm_source=twitter"}]}},
+				{"id": "11", "text": "A quiet one", "public_metrics": {"like_count": 1}}
+			]}`))
+		case "/2/users/2/tweets":
+			w.Write([]byte(`{"data": [{"id": "20", "text": "Hello", "public_metrics": {"like_count": 50}}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := NewTwitterClient("token", WithBaseURL(ts.URL+"/2/"))
+	require.NoError(t, err)
+
+	src := models.Source{Type: models.SourceTypeTwitter, Names: []string{"@golang", "rob"}, NumItems: 1, TimePeriod: "day", Now: now}
+	blocks, err := client.Fetch(src)
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+	require.Equal(t, "@golang", blocks[0].Title)
+	require.Equal(t, models.Story{
+		ID:           "twitter/10",
+		Author:       "@golang",
+		Link:         "https://blog.golang.org/go1.16",
+		Hostname:     "blog.golang.org",
+		CommentsLink: "https://twitter.com/golang/status/10",
+		NumComments:  5,
+		Score:        145,
+		Text:         `Go 1\.16 is out\! \*Embed\* files &amp; more <https://blog.golang.org/go1.16?utm_source=twitter>`,
+	}, blocks[0].Stories[0])
+	require.Equal(t, "@rob", blocks[1].Title)
+
+	// Merged blocks rank every account's tweets together, and user IDs are cached
+	src.Merge = true
+	src.NumItems = 2
+	blocks, err = client.Fetch(src)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "Twitter", blocks[0].Title)
+	require.Len(t, blocks[0].Stories, 2)
+	require.Equal(t, "@golang", blocks[0].Stories[0].Author)
+	require.Equal(t, "@rob", blocks[0].Stories[1].Author)
+	require.Equal(t, 2, lookups)
+
+	_, err = client.Fetch(models.Source{Type: models.SourceTypeTwitter, Name: "nobody", NumItems: 1, TimePeriod: "day"})
+	require.Error(t, err)
+}
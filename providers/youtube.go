@@ -0,0 +1,69 @@
+package providers
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/hebo/mailshine/models"
+)
+
+const youTubeFeedURL = "https://www.youtube.com/feeds/videos.xml"
+
+// NewYouTubeClient creates a new YouTubeClient
+func NewYouTubeClient(opts ...Option) *YouTubeClient {
+	return &YouTubeClient{FeedClient{newClientOptions(youTubeFeedURL, opts)}}
+}
+
+// YouTubeClient reads the uploads feeds of YouTube channels
+type YouTubeClient struct {
+	FeedClient
+}
+
+// FetchChannel fetches the uploads feed of a channel
+func (y *YouTubeClient) FetchChannel(channelID string) (Feed, error) {
+	return y.FetchFeed(y.baseURL + "?channel_id=" + url.QueryEscape(channelID))
+}
+
+// Fetch fetches videos uploaded within the source's time period, as one
+// block per channel or a single merged block
+func (y *YouTubeClient) Fetch(src models.Source) ([]models.Block, error) {
+	var blocks []models.Block
+	merged := models.Block{Title: src.Title}
+	if merged.Title == "" {
+		merged.Title = "YouTube"
+	}
+	for _, channelID := range src.AllNames() {
+		feed, err := y.FetchChannel(channelID)
+		if err != nil {
+			return nil, fmt.Errorf("fetch channel %q: %w", channelID, err)
+		}
+
+		var stories []models.Story
+		for _, entry := range feed.Since(src.Cutoff()) {
+			story := entry.ToStory()
+			story.ID = entry.StoryID(channelID)
+			stories = append(stories, story)
+		}
+		if len(stories) > src.NumItems {
+			stories = stories[:src.NumItems]
+		}
+
+		if src.Merge {
+			merged.Stories = append(merged.Stories, stories...)
+			continue
+		}
+
+		title := src.Title
+		if title == "" {
+			title = feed.Title
+		}
+		blocks = append(blocks, models.Block{Title: title, Stories: stories})
+	}
+
+	if src.Merge {
+		blocks = append(blocks, merged)
+	}
+	return blocks, nil
+}
+
+var _ Provider = &YouTubeClient{}
@@ -0,0 +1,45 @@
+package providers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestYouTubeClient_Fetch(t *testing.T) {
+	feed, err := ioutil.ReadFile("testdata/youtube.xml")
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "UC_x5XG1OV2P6uZZ5FSM9Ttw", r.URL.Query().Get("channel_id"))
+		w.Write(feed)
+	}))
+	defer ts.Close()
+
+	client := NewYouTubeClient(WithBaseURL(ts.URL + "/feeds/videos.xml"))
+	blocks, err := client.Fetch(models.Source{
+		Type:       models.SourceTypeYouTube,
+		Name:       "UC_x5XG1OV2P6uZZ5FSM9Ttw",
+		NumItems:   5,
+		TimePeriod: "week",
+		Now:        time.Date(2020, 12, 3, 8, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, "Google for Developers", blocks[0].Title)
+
+	// Channel feeds have no durations, and videos are dated by when they were
+	// published, not last updated
+	require.Equal(t, []models.Story{{
+		ID:       "UC_x5XG1OV2P6uZZ5FSM9Ttw#yt:video:8pDqJVdNa44",
+		Title:    "What's new in Go",
+		Link:     "https://www.youtube.com/watch?v=8pDqJVdNa44",
+		Hostname: "www.youtube.com",
+		Text:     "Catch up on generics, fuzzing &amp; workspaces\\.\n\nResources:\nGo blog → <https://go.dev/blog>",
+		Image:    "https://i4.ytimg.com/vi/8pDqJVdNa44/hqdefault.jpg",
+	}}, blocks[0].Stories)
+}
@@ -22,9 +22,15 @@ var (
 	}
 )
 
+// markdownRenderer skips raw HTML and unsafe links, since story text and
+// comments are written by anyone
+var markdownRenderer = blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+	Flags: blackfriday.CommonHTMLFlags | blackfriday.SkipHTML | blackfriday.Safelink,
+})
+
 // formatMarkdown converts markdown strings to HTML and truncates
 func formatMarkdown(text string, length int) template.HTML {
-	output := blackfriday.Run([]byte(text))
+	output := blackfriday.Run([]byte(text), blackfriday.WithRenderer(markdownRenderer))
 	trunced, err := truncateHTML(length, template.HTML(output))
 	if err != nil {
 		log.Printf("error parsing markdown: %s", err)
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -135,6 +136,7 @@ func (s Server) GetFeedRSS(w http.ResponseWriter, r *http.Request, ps httprouter
 			Link:        &feeds.Link{Href: digestURL(s.baseURL, digest.FeedName, digest.ID)},
 			Description: renderDigest(digest, s.baseURL),
 			Created:     digest.CreatedAt,
+			Enclosure:   digestEnclosure(digest),
 		})
 	}
 
@@ -165,6 +167,30 @@ func (s Server) GetDigest(w http.ResponseWriter, r *http.Request, ps httprouter.
 	w.Write([]byte(renderDigest(digest, s.baseURL)))
 }
 
+// digestEnclosure returns the first story image in a digest, so feed readers
+// can show it as a thumbnail
+func digestEnclosure(digest models.Digest) *feeds.Enclosure {
+	for _, block := range digest.Content {
+		for _, story := range block.Stories {
+			if story.Image == "" {
+				continue
+			}
+
+			imgURL, err := url.Parse(story.Image)
+			if err != nil {
+				continue
+			}
+			mimeType := mime.TypeByExtension(path.Ext(imgURL.Path))
+			if !strings.HasPrefix(mimeType, "image/") {
+				mimeType = "image/jpeg"
+			}
+			// The size isn't known without fetching the image
+			return &feeds.Enclosure{Url: story.Image, Type: mimeType, Length: "0"}
+		}
+	}
+	return nil
+}
+
 const templateDigest = "server/digest.html"
 
 // renderDigest renders the HTML representation of a digest
@@ -178,6 +204,7 @@ func renderDigest(digest models.Digest, baseURL string) string {
 			"trunc":      models.Truncate,
 			"apolloLink": apolloURLHelper,
 			"md":         formatMarkdown,
+			"duration":   formatDuration,
 		}).ParseFiles(templateDigest)
 	if err != nil {
 		log.Printf("Failed to parse template: %s", err)
@@ -197,6 +224,16 @@ func renderDigest(digest models.Digest, baseURL string) string {
 	return buff.String()
 }
 
+// formatDuration formats a duration as a clock, e.g. "1:02:03" or "4:05"
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, sec := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
+
 func apolloURLHelper(s string) template.URL {
 	u, _ := url.Parse(s)
 	u.Scheme = "apollo"
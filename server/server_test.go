@@ -1,8 +1,18 @@
 package server
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/hebo/mailshine/models"
+	"github.com/hebo/mailshine/providers"
+	"github.com/hebo/mailshine/providers/reddittest"
+	"github.com/hebo/mailshine/service"
 	"github.com/stretchr/testify/require"
 )
 
@@ -13,3 +23,82 @@ func Test_apolloURLHelper(t *testing.T) {
 
 	require.Equal(t, want, got)
 }
+
+func Test_formatDuration(t *testing.T) {
+	require.Equal(t, "4:05", formatDuration(4*time.Minute+5*time.Second))
+	require.Equal(t, "1:02:03", formatDuration(time.Hour+2*time.Minute+3*time.Second))
+}
+
+func Test_formatMarkdown(t *testing.T) {
+	require.Equal(t, "<p>Share <strong>your</strong> game</p>\n", string(formatMarkdown("Share **your** game", 100)))
+	// Raw HTML and unsafe links are left out, since anyone can write story text
+	require.Equal(t, "<p>Hi</p>\n", string(formatMarkdown(`<img src=x onerror=alert(1)>Hi`, 100)))
+	require.NotContains(t, string(formatMarkdown(`[x](javascript:alert(1))`, 100)), "href")
+	require.Contains(t, string(formatMarkdown(`[x](https://example.com/)`, 100)), `href="https://example.com/"`)
+}
+
+func Test_digestEnclosure(t *testing.T) {
+	require.Nil(t, digestEnclosure(models.Digest{}))
+
+	digest := models.Digest{Content: models.ContentBlocks{
+		{Stories: []models.Story{{Title: "No image"}}},
+		{Stories: []models.Story{{Image: "https://preview.redd.it/abc.png?width=640&s=x"}}},
+	}}
+	got := digestEnclosure(digest)
+	require.Equal(t, "https://preview.redd.it/abc.png?width=640&s=x", got.Url)
+	require.Equal(t, "image/png", got.Type)
+}
+
+func TestServer_digests(t *testing.T) {
+	// Templates are loaded relative to the repository root
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(".."))
+	defer os.Chdir(wd)
+
+	fake := reddittest.NewServer()
+	defer fake.Close()
+	fake.AddPosts(
+		reddittest.Post{ID: "a", Subreddit: "games", Title: "Patch notes", URL: "https://example.com/patch", Score: 500, NumComments: 80},
+		reddittest.Post{ID: "b", Subreddit: "games", Title: "Screenshot Saturday", Selftext: "Share **your** game", Score: 200,
+			Comments: []reddittest.Comment{{Author: "dev", Body: "Mine is a _puzzle_ game", Score: 12}}},
+	)
+
+	dir, err := ioutil.TempDir("", "mailshine")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := models.NewDB(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+
+	reddit, err := providers.NewRedditClient(reddittest.ClientID, reddittest.ClientSecret,
+		providers.WithBaseURL(fake.URL), providers.WithTokenURL(fake.TokenURL()))
+	require.NoError(t, err)
+	registry := providers.NewRegistry()
+	registry.Register(models.SourceTypeReddit, reddit)
+
+	feeds := models.FeedConfigMap{"games": {
+		Title:      "Games",
+		Reddits:    []string{"games"},
+		NumItems:   5,
+		TimePeriod: "day",
+		Comments:   1,
+		Schedule:   "45 7 * * *",
+	}}
+	require.NoError(t, service.NewService(db, feeds, registry).CreateAllDigests())
+	srv := New(db, feeds, "https://mailshine.example.com")
+
+	res := httptest.NewRecorder()
+	srv.router.ServeHTTP(res, httptest.NewRequest("GET", "/feeds/games/rss", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), "<title>Games #1</title>")
+	require.Contains(t, res.Body.String(), "<link>/feeds/games/digests/1</link>")
+
+	res = httptest.NewRecorder()
+	srv.router.ServeHTTP(res, httptest.NewRequest("GET", "/feeds/games/digests/1", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	body := res.Body.String()
+	require.Contains(t, body, `<a href="https://example.com/patch">`)
+	require.Contains(t, body, "<strong>your</strong>")
+	require.Contains(t, body, "<em>puzzle</em>")
+	require.Contains(t, body, "u/dev")
+}
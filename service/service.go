@@ -1,8 +1,11 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/hebo/mailshine/models"
@@ -11,17 +14,87 @@ import (
 )
 
 type Service struct {
-	db           models.DB
-	feeds        models.FeedConfigMap
-	redditClient *providers.RedditClient
+	db        models.DB
+	feeds     models.FeedConfigMap
+	providers *providers.Registry
+	loc       *time.Location
+
+	// fetchSlots and providerSlots hold a value for each source being fetched,
+	// across all feeds, and for each source type with a limit
+	fetchSlots    chan struct{}
+	providerSlots map[string]chan struct{}
+	digestTimeout time.Duration
+	// now is the current time, pinned when replaying recorded requests
+	now func() time.Time
+}
+
+const (
+	defaultConcurrency   = 4
+	defaultDigestTimeout = 10 * time.Minute
+)
+
+// Option configures a Service
+type Option func(*Service)
+
+// WithConcurrency limits how many sources are fetched at once, across all
+// feeds. Values below 1 are ignored
+func WithConcurrency(n int) Option {
+	return func(s *Service) {
+		if n > 0 {
+			s.fetchSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithProviderConcurrency limits how many sources of a type are fetched at
+// once, e.g. to stay under an API's rate limit. Values below 1 are ignored
+func WithProviderConcurrency(sourceType string, n int) Option {
+	return func(s *Service) {
+		if n > 0 {
+			s.providerSlots[sourceType] = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithDigestTimeout limits how long fetching the sources of a digest can take.
+// Sources which don't finish in time are left out. Non-positive values are ignored
+func WithDigestTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.digestTimeout = d
+		}
+	}
+}
+
+// WithClock sets the function the service gets the current time from, e.g. to
+// create digests at the time a cassette was recorded. Nil is ignored
+func WithClock(now func() time.Time) Option {
+	return func(s *Service) {
+		if now != nil {
+			s.now = now
+		}
+	}
 }
 
 // NewService creates a new Service
-func NewService(db models.DB, fc models.FeedConfigMap, reddit *providers.RedditClient) Service {
+func NewService(db models.DB, fc models.FeedConfigMap, registry *providers.Registry, opts ...Option) Service {
+	loc, err := time.LoadLocation(timezone) // use other time zones such as MST, IST
+	if err != nil {
+		log.Fatalln("failed to get timezone: ", err)
+	}
+
 	svc := Service{
-		db:           db,
-		feeds:        fc,
-		redditClient: reddit,
+		db:            db,
+		feeds:         fc,
+		providers:     registry,
+		loc:           loc,
+		fetchSlots:    make(chan struct{}, defaultConcurrency),
+		providerSlots: map[string]chan struct{}{},
+		digestTimeout: defaultDigestTimeout,
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(&svc)
 	}
 
 	return svc
@@ -31,12 +104,7 @@ const timezone = "America/Los_Angeles"
 
 // StartScheduler begins scheduling for Digest generation
 func (s Service) StartScheduler() error {
-	loc, err := time.LoadLocation(timezone) // use other time zones such as MST, IST
-	if err != nil {
-		log.Fatalln("failed to get timezone: ", err)
-	}
-
-	scheduler := cron.New(cron.WithLocation(loc))
+	scheduler := cron.New(cron.WithLocation(s.loc))
 	for name, conf := range s.feeds {
 		count, err := s.db.CountDigestsByFeed(name)
 		if err != nil {
@@ -46,14 +114,20 @@ func (s Service) StartScheduler() error {
 
 		if count == 0 {
 			log.Printf("No digests for feed %q, fetching initial\n", name)
-			s.createDigest(name)
+			err = s.createDigest(name)
+			if err != nil {
+				log.Printf("Failed to create digest for %q: %s\n", name, err)
+			}
 		}
 
 		n := name
 		log.Printf("Scheduling %q\n", n)
 		_, err = scheduler.AddFunc(conf.Schedule, func() {
 			log.Printf("Scheduler triggered for %q\n", n)
-			s.createDigest(n)
+			err := s.createDigest(n)
+			if err != nil {
+				log.Printf("Failed to create digest for %q: %s\n", n, err)
+			}
 		})
 		if err != nil {
 			return fmt.Errorf("failed to schedule job: %w", err)
@@ -78,28 +152,344 @@ func (s Service) createDigest(feedName string) error {
 	dg := models.Digest{
 		Title:     fmt.Sprintf("%s #%d", feedConf.Title, count+1),
 		FeedName:  feedName,
-		CreatedAt: time.Now(),
+		CreatedAt: s.now(),
+	}
+
+	var since time.Time
+	if count > 0 {
+		latest, err := s.db.GetLatestDigestByFeed(feedName)
+		if err != nil {
+			return fmt.Errorf("failed to get latest digest: %w", err)
+		}
+		since = latest.CreatedAt
 	}
 
-	for _, subreddit := range feedConf.Reddits {
-		listing, err := s.redditClient.FetchSubreddit(
-			subreddit, feedConf.TimePeriod, feedConf.NumItems)
+	seen := map[string]bool{}
+	if feedConf.DedupeDigests > 0 {
+		seen, err = s.db.GetDeliveredStoryKeys(feedName, feedConf.DedupeDigests)
 		if err != nil {
-			return fmt.Errorf("fetch subreddit %q: %w", subreddit, err)
+			return fmt.Errorf("failed to get delivered stories: %w", err)
+		}
+	}
+	dedupe := feedConf.DedupeDigests > 0 || feedConf.DedupeLinks
+
+	sources := feedConf.AllSources()
+	numItems := make([]int, len(sources))
+	for i := range sources {
+		src := &sources[i]
+		numItems[i] = src.NumItems
+		if dedupe {
+			// Fetch extra to replace the stories that get skipped
+			src.NumItems *= dedupeFetchFactor
+		}
+		src.Since = since
+		src.Now = dg.CreatedAt
+		if src.TimePeriod == models.TimePeriodAuto {
+			interval, err := models.ScheduleInterval(feedConf.Schedule, s.loc, dg.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to get schedule interval: %w", err)
+			}
+			src.SetWindow(interval)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.digestTimeout)
+	defer cancel()
+	results := s.fetchSources(ctx, sources)
+
+	var failed []error
+	// fetched is the IDs of the stories each source fetched
+	fetched := make([]map[string]bool, len(results))
+	for i, res := range results {
+		if res.err != nil {
+			// One banned subreddit or slow source shouldn't hold up the whole digest
+			log.Printf("Skipping source: %s", res.err)
+			failed = append(failed, res.err)
+			continue
+		}
+
+		blocks := res.blocks
+		fetched[i] = storyIDs(blocks)
+		if dedupe {
+			blocks = dedupeBlocks(blocks, seen, numItems[i], feedConf.DedupeLinks)
 		}
+		dg.Content = append(dg.Content, blocks...)
+	}
+	if len(failed) > 0 && len(failed) == len(sources) {
+		return fmt.Errorf("all %d sources failed, first: %w", len(sources), failed[0])
+	}
 
-		blk := listing.ToBlock("r/" + subreddit)
-		dg.Content = append(dg.Content, blk)
+	dg.Content = clusterBlocks(dg.Content)
+	if feedConf.Layout == models.LayoutMerged {
+		dg.Content = []models.Block{mergeBlocks(dg.Content, feedConf.Title, feedConf.NumItems)}
 	}
 
-	err = s.db.InsertDigest(dg)
+	err = s.db.InsertDigest(dg, feedConf.DedupeDigests)
 	if err != nil {
 		return fmt.Errorf("failed to insert feed: %s", err)
 	}
 	log.Printf("Inserted feed %q: %s\n", feedName, dg.Title)
+
+	s.markDelivered(sources, fetched, dg.Content)
 	return nil
 }
 
+// markDelivered tells providers which of each source's stories made it into
+// the digest. Failures are only logged, since the digest is already stored
+func (s Service) markDelivered(sources []models.Source, fetched []map[string]bool, content []models.Block) {
+	for i, src := range sources {
+		var delivered []models.Story
+		for _, block := range content {
+			for _, story := range block.Stories {
+				if story.ID != "" && fetched[i][story.ID] {
+					delivered = append(delivered, story)
+				}
+			}
+		}
+
+		err := s.providers.MarkDelivered(src, delivered)
+		if err != nil {
+			log.Printf("Failed to mark stories delivered for %s source %q: %s", src.Type, src.AllNames(), err)
+		}
+	}
+}
+
+// storyIDs returns the IDs of the stories in blocks
+func storyIDs(blocks []models.Block) map[string]bool {
+	ids := map[string]bool{}
+	for _, block := range blocks {
+		for _, story := range block.Stories {
+			if story.ID != "" {
+				ids[story.ID] = true
+			}
+		}
+	}
+	return ids
+}
+
+// fetchResult is the outcome of fetching one source
+type fetchResult struct {
+	blocks []models.Block
+	err    error
+}
+
+// fetchSources fetches sources concurrently, within the service's limits, and
+// returns their results in the same order as the sources
+func (s Service) fetchSources(ctx context.Context, sources []models.Source) []fetchResult {
+	results := make([]fetchResult, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src models.Source) {
+			defer wg.Done()
+			blocks, err := s.fetchSource(ctx, src)
+			if err != nil {
+				err = fmt.Errorf("fetch %s source %q: %w", src.Type, src.AllNames(), err)
+			}
+			results[i] = fetchResult{blocks, err}
+		}(i, src)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// fetchSource fetches a source once there's a free slot for it, returning
+// early if ctx is done. Slots are held until the fetch finishes, even after
+// ctx is done, so providers which can't be stopped still stay within limits
+func (s Service) fetchSource(ctx context.Context, src models.Source) ([]models.Block, error) {
+	release, err := s.acquireSlots(ctx, src.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan fetchResult, 1)
+	go func() {
+		defer release()
+		blocks, err := s.providers.FetchContext(ctx, src)
+		done <- fetchResult{blocks, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.blocks, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquireSlots takes a slot for the source type, if it has a limit, and one
+// overall, and returns a function which releases them. The slot for the type
+// is taken first, so sources waiting on a busy provider don't hold up others
+func (s Service) acquireSlots(ctx context.Context, sourceType string) (func(), error) {
+	slots, limited := s.providerSlots[sourceType]
+	if limited {
+		err := acquire(ctx, slots)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := acquire(ctx, s.fetchSlots)
+	if err != nil {
+		if limited {
+			<-slots
+		}
+		return nil, err
+	}
+
+	return func() {
+		<-s.fetchSlots
+		if limited {
+			<-slots
+		}
+	}, nil
+}
+
+// acquire takes a slot, waiting until one is free or ctx is done
+func acquire(ctx context.Context, slots chan struct{}) error {
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dedupeFetchFactor is how many more stories are fetched when deduping
+const dedupeFetchFactor = 2
+
+// dedupeBlocks drops stories with a key in seen, keeping up to n per block.
+// If addKept is set, kept stories are added to seen so later blocks skip them
+func dedupeBlocks(blocks []models.Block, seen map[string]bool, n int, addKept bool) []models.Block {
+	for i, block := range blocks {
+		stories := block.Stories[:0]
+		for _, story := range block.Stories {
+			if len(stories) == n {
+				break
+			}
+
+			keys := story.Keys()
+			delivered := false
+			for _, k := range keys {
+				delivered = delivered || seen[k]
+			}
+			if delivered {
+				continue
+			}
+
+			if addKept {
+				for _, k := range keys {
+					seen[k] = true
+				}
+			}
+			stories = append(stories, story)
+		}
+		blocks[i].Stories = stories
+	}
+	return blocks
+}
+
+// clusterBlocks groups stories with the same link into the first of them,
+// which lists where the others were posted as Discussions
+func clusterBlocks(blocks []models.Block) []models.Block {
+	first := map[string]*models.Story{}
+	for i, block := range blocks {
+		stories := block.Stories[:0]
+		for _, story := range block.Stories {
+			// Posts shown by author are their own content, rather than a link
+			key := story.LinkKey()
+			if key == "" || story.Author != "" {
+				stories = append(stories, story)
+				continue
+			}
+
+			if f, ok := first[key]; ok {
+				if story.CommentsLink == "" {
+					continue
+				}
+				source := story.Subreddit
+				if source == "" {
+					source = block.Title
+				}
+				f.Discussions = append(f.Discussions, models.Discussion{
+					Source:       source,
+					CommentsLink: story.CommentsLink,
+					NumComments:  story.NumComments,
+				})
+				continue
+			}
+
+			stories = append(stories, story)
+			first[key] = &stories[len(stories)-1]
+		}
+		blocks[i].Stories = stories
+	}
+	return blocks
+}
+
+// mergeBlocks ranks the stories of all blocks in a single block of up to n.
+// Scores are relative to the median of their block, so busy sources don't
+// drown out quiet ones. Sources without scores are ranked by comments
+func mergeBlocks(blocks []models.Block, title string, n int) models.Block {
+	type ranked struct {
+		story models.Story
+		score float64
+	}
+
+	merged := models.Block{Title: title}
+	var pool []ranked
+	for i, block := range blocks {
+		if i == 0 {
+			merged.Type = block.Type
+		} else if block.Type != merged.Type {
+			merged.Type = models.BlockTypeMixed
+		}
+
+		activity := make([]int, len(block.Stories))
+		for j, story := range block.Stories {
+			activity[j] = storyActivity(story)
+		}
+		typical := median(activity)
+		if typical == 0 {
+			typical = 1
+		}
+
+		for j, story := range block.Stories {
+			story.Source = block.Title
+			pool = append(pool, ranked{story, float64(activity[j]) / float64(typical)})
+		}
+	}
+
+	// Ties keep the order of the sources
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].score > pool[j].score
+	})
+	for _, r := range pool {
+		if len(merged.Stories) == n {
+			break
+		}
+		merged.Stories = append(merged.Stories, r.story)
+	}
+	return merged
+}
+
+// storyActivity is how popular a story is, by score or number of comments
+func storyActivity(story models.Story) int {
+	if story.Score > 0 {
+		return story.Score
+	}
+	return story.TotalComments()
+}
+
+func median(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int{}, values...)
+	sort.Ints(sorted)
+	return sorted[len(sorted)/2]
+}
+
 // CreateAllDigests generates a digest for all configured feeds
 func (s Service) CreateAllDigests() error {
 	for name := range s.feeds {
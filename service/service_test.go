@@ -0,0 +1,275 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hebo/mailshine/models"
+	"github.com/hebo/mailshine/providers"
+	"github.com/hebo/mailshine/providers/reddittest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_dedupeBlocks(t *testing.T) {
+	blocks := []models.Block{
+		{Title: "r/sanfrancisco", Stories: []models.Story{
+			{ID: "t3_a", Title: "Delivered last week", Link: "https://example.com/old"},
+			{ID: "t3_b", Title: "News", Link: "https://www.sfchronicle.com/story/"},
+			{ID: "t3_c", Title: "Other", Link: "https://example.com/c"},
+			{ID: "t3_d", Title: "Extra", Link: "https://example.com/d"},
+		}},
+		{Title: "r/bayarea", Stories: []models.Story{
+			{ID: "t3_e", Title: "Same news", Link: "https://sfchronicle.com/story"},
+			{ID: "t3_f", Title: "Bay Area", Link: "https://example.com/f"},
+		}},
+	}
+	seen := map[string]bool{"id:t3_a": true}
+
+	got := dedupeBlocks(blocks, seen, 2, true)
+	require.Equal(t, []string{"News", "Other"}, titles(got[0]))
+	require.Equal(t, []string{"Bay Area"}, titles(got[1]))
+}
+
+func Test_clusterBlocks(t *testing.T) {
+	blocks := []models.Block{
+		{Title: "r/sanfrancisco", Stories: []models.Story{
+			{Title: "News", Link: "https://www.sfchronicle.com/story", Subreddit: "r/sanfrancisco", NumComments: 10, CommentsLink: "https://old.reddit.com/1"},
+			{Title: "Other", Link: "https://example.com/c", NumComments: 1},
+		}},
+		{Title: "r/bayarea", Stories: []models.Story{
+			{Title: "Same news", Link: "https://sfchronicle.com/story/", Subreddit: "r/bayarea", NumComments: 5, CommentsLink: "https://old.reddit.com/2"},
+			{Title: "Bay Area", Link: "https://example.com/f"},
+		}},
+		{Title: "Hacker News", Stories: []models.Story{
+			{Title: "News again", Link: "https://sfchronicle.com/story", NumComments: 7, CommentsLink: "https://news.ycombinator.com/item?id=3"},
+		}},
+	}
+
+	got := clusterBlocks(blocks)
+	require.Equal(t, []string{"News", "Other"}, titles(got[0]))
+	require.Equal(t, []string{"Bay Area"}, titles(got[1]))
+	require.Empty(t, got[2].Stories)
+
+	news := got[0].Stories[0]
+	require.Equal(t, []models.Discussion{
+		{Source: "r/bayarea", CommentsLink: "https://old.reddit.com/2", NumComments: 5},
+		{Source: "Hacker News", CommentsLink: "https://news.ycombinator.com/item?id=3", NumComments: 7},
+	}, news.Discussions)
+	require.Equal(t, 22, news.TotalComments())
+}
+
+func Test_mergeBlocks(t *testing.T) {
+	blocks := []models.Block{
+		{Title: "r/programming", Type: "reddit", Stories: []models.Story{
+			{Title: "Big", Score: 3000},
+			{Title: "Typical", Score: 1000},
+			{Title: "Small", Score: 500},
+		}},
+		{Title: "r/golang", Type: "reddit", Stories: []models.Story{
+			{Title: "Go big", Score: 200},
+			{Title: "Go typical", Score: 50},
+			{Title: "Go small", Score: 10},
+		}},
+	}
+
+	got := mergeBlocks(blocks, "Programming", 4)
+	require.Equal(t, "Programming", got.Title)
+	require.Equal(t, "reddit", got.Type)
+	require.Equal(t, []string{"Go big", "Big", "Typical", "Go typical"}, titles(got))
+	require.Equal(t, "r/golang", got.Stories[0].Source)
+
+	blocks = append(blocks, models.Block{Title: "Hacker News", Type: "hackernews", Stories: []models.Story{
+		{Title: "Unscored", NumComments: 40},
+	}})
+	got = mergeBlocks(blocks, "Programming", 10)
+	require.Equal(t, models.BlockTypeMixed, got.Type)
+	require.Len(t, got.Stories, 7)
+}
+
+func titles(b models.Block) []string {
+	var t []string
+	for _, s := range b.Stories {
+		t = append(t, s.Title)
+	}
+	return t
+}
+
+func TestService_createDigest(t *testing.T) {
+	fake := reddittest.NewServer()
+	defer fake.Close()
+	fake.AddPosts(
+		reddittest.Post{ID: "a", Subreddit: "sanfrancisco", Title: "Muni news", URL: "https://sfchronicle.com/muni", Score: 300, NumComments: 40},
+		reddittest.Post{ID: "b", Subreddit: "sanfrancisco", Title: "Fog", Score: 100},
+		reddittest.Post{ID: "c", Subreddit: "bayarea", Title: "Muni news", URL: "https://www.sfchronicle.com/muni?utm_source=reddit", Score: 90, NumComments: 12},
+	)
+	fake.SetError("private", http.StatusForbidden, "private")
+
+	svc := newTestService(t, fake, models.FeedConfig{
+		Title:      "Local",
+		Reddits:    []string{"sanfrancisco", "private", "bayarea"},
+		NumItems:   5,
+		TimePeriod: "week",
+		Schedule:   "0 8 * * 6",
+	})
+	require.NoError(t, svc.createDigest("local"))
+
+	digests, err := svc.db.GetDigestsByFeed("local")
+	require.NoError(t, err)
+	require.Len(t, digests, 1)
+	require.Equal(t, "Local #1", digests[0].Title)
+
+	// The private subreddit is skipped, and the shared link is grouped
+	blocks := digests[0].Content
+	require.Len(t, blocks, 2)
+	require.Equal(t, []string{"Muni news", "Fog"}, titles(blocks[0]))
+	require.Empty(t, blocks[1].Stories)
+	require.Equal(t, 52, blocks[0].Stories[0].TotalComments())
+
+	// A digest isn't stored if none of its sources could be fetched
+	svc.feeds["local"] = models.FeedConfig{Title: "Local", Reddits: []string{"private"}, NumItems: 5, TimePeriod: "week"}
+	require.Error(t, svc.createDigest("local"))
+}
+
+// counter tracks how many things are happening at once
+type counter struct {
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (c *counter) add(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current += n
+	if c.current > c.max {
+		c.max = c.current
+	}
+}
+
+// slowProvider takes the duration in a source's name to fetch it, and counts
+// how many sources it, and all providers sharing total, fetch at once
+type slowProvider struct {
+	inFlight counter
+	total    *counter
+}
+
+func (p *slowProvider) Fetch(src models.Source) ([]models.Block, error) {
+	p.inFlight.add(1)
+	p.total.add(1)
+	defer p.inFlight.add(-1)
+	defer p.total.add(-1)
+
+	d, err := time.ParseDuration(src.Name)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(d)
+	return []models.Block{{Title: src.Name}}, nil
+}
+
+func TestService_fetchSources(t *testing.T) {
+	total := &counter{}
+	slow, other := &slowProvider{total: total}, &slowProvider{total: total}
+	registry := providers.NewRegistry()
+	registry.Register("slow", slow)
+	registry.Register("other", other)
+	svc := NewService(models.DB{}, nil, registry, WithConcurrency(3), WithProviderConcurrency("slow", 2),
+		WithDigestTimeout(time.Second))
+
+	var sources []models.Source
+	for _, name := range []string{"50ms", "40ms", "30ms", "20ms", "10ms"} {
+		sources = append(sources, models.Source{Type: "slow", Name: name}, models.Source{Type: "other", Name: name})
+	}
+	sources = append(sources, models.Source{Type: "other", Name: "bad"})
+
+	results := svc.fetchSources(context.Background(), sources)
+	require.Len(t, results, len(sources))
+	for i, res := range results[:len(results)-1] {
+		require.NoError(t, res.err)
+		require.Equal(t, []models.Block{{Title: sources[i].Name, Type: sources[i].Type}}, res.blocks)
+	}
+	require.Error(t, results[len(results)-1].err)
+	require.Equal(t, 2, slow.inFlight.max)
+	require.Equal(t, 3, total.max)
+
+	// Sources still running at the deadline fail, without waiting for them
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results = svc.fetchSources(ctx, []models.Source{{Type: "slow", Name: "1ms"}, {Type: "slow", Name: "300ms"}})
+	require.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	require.NoError(t, results[0].err)
+	require.True(t, errors.Is(results[1].err, context.DeadlineExceeded))
+
+	// but they keep their slots until they finish
+	results = svc.fetchSources(context.Background(), []models.Source{{Type: "slow", Name: "1ms"}, {Type: "slow", Name: "1ms"}})
+	require.NoError(t, results[0].err)
+	require.NoError(t, results[1].err)
+	require.Equal(t, 2, slow.inFlight.max)
+}
+
+func newTestService(t *testing.T, fake *reddittest.Server, fc models.FeedConfig) Service {
+	dir, err := ioutil.TempDir("", "mailshine")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	db, err := models.NewDB(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+
+	reddit, err := providers.NewRedditClient(reddittest.ClientID, reddittest.ClientSecret,
+		providers.WithBaseURL(fake.URL), providers.WithTokenURL(fake.TokenURL()))
+	require.NoError(t, err)
+	registry := providers.NewRegistry()
+	registry.Register(models.SourceTypeReddit, reddit)
+
+	return NewService(db, models.FeedConfigMap{"local": fc}, registry)
+}
+
+// markingProvider returns fixed stories, and records which were delivered
+type markingProvider struct {
+	stories   []models.Story
+	delivered []string
+}
+
+func (p *markingProvider) Fetch(src models.Source) ([]models.Block, error) {
+	return []models.Block{{Title: src.Name, Stories: append([]models.Story{}, p.stories...)}}, nil
+}
+
+func (p *markingProvider) MarkDelivered(src models.Source, stories []models.Story) error {
+	for _, story := range stories {
+		p.delivered = append(p.delivered, story.ID)
+	}
+	return nil
+}
+
+func TestService_createDigestMarksDelivered(t *testing.T) {
+	fake := reddittest.NewServer()
+	defer fake.Close()
+	fake.AddPosts(reddittest.Post{ID: "a", Subreddit: "sanfrancisco", Title: "Fog", Score: 1000})
+
+	svc := newTestService(t, fake, models.FeedConfig{
+		Title:      "Local",
+		NumItems:   2,
+		TimePeriod: "week",
+		Layout:     models.LayoutMerged,
+		Sources: []models.Source{
+			{Type: models.SourceTypeReddit, Name: "sanfrancisco"},
+			{Type: "mail", Name: "inbox"},
+		},
+	})
+	mail := &markingProvider{stories: []models.Story{
+		{ID: "inbox/1", Title: "Issue 1", NumComments: 5},
+		{ID: "inbox/2", Title: "Issue 2", NumComments: 1},
+	}}
+	svc.providers.Register("mail", mail)
+
+	// Only the story which makes it into the merged block is delivered
+	require.NoError(t, svc.createDigest("local"))
+	require.Equal(t, []string{"inbox/1"}, mail.delivered)
+}